@@ -4,6 +4,7 @@ import (
     "encoding/json"
     "os"
     "fmt"
+    "net/url"
     "strings"
 )
 
@@ -12,6 +13,95 @@ type Cfg struct {
     DefaultVhost   string
     ListenPort      string
     Vhosts map[string]interface{}
+    AdminListen     string      // Optional admin HTTP listener, e.g. "127.0.0.1:8021"
+    ListingCacheEntries int
+    ContentCacheDir string      // Disk cache of RETR contents, disabled if empty
+    ContentCacheSize int64
+    ContentCacheFills int
+    HttpClient HttpClient       // Defaults for all mounts
+}
+
+// Upstream HTTP transport settings, durations in seconds, 0 means no limit
+type HttpClient struct {
+    DialTimeout float64
+    TlsTimeout float64
+    ResponseHeaderTimeout float64
+    ReadTimeout float64         // Longest wait for body data
+    IdleTimeout float64         // Idle keep-alive connections are closed after this
+    MaxIdleConns int            // Per host
+    MaxConnsPerHost int
+    Http2 bool
+    Proxy string                // "http://host:port", empty uses $HTTP_PROXY etc.
+    Redirects string            // "follow", "same-host" or "refuse"
+}
+
+// A mount maps a path prefix to an upstream.
+// Plain "ip[:port]" values are HTTP vhosts, other values are URLs
+// whose scheme selects the backend, e.g. "file:///srv/releases".
+// A mount can also be an object with the URL in "url" and per-mount options.
+// "url" can be a list of mirrors differing only by host.
+type Mount struct {
+    Prefix string
+    Scheme string
+    Host string
+    Hosts []string      // All mirrors, Host is the first one
+    Path string
+    User string
+    Password string
+    // S3 options
+    Region string
+    AccessKey string
+    SecretKey string
+    // Browse .zip and .iso files of HTTP mounts as directories
+    Archives bool
+    // Virtual <dir>.tar, <dir>.tar.gz and <dir>.zip
+    DirArchives bool
+    ArchiveMaxSize int64
+    ArchiveMaxDepth int
+    // Virtual uncompressed names next to .gz, .bz2, .xz and .zst files
+    Decompress bool
+    // Seconds listings are cached, 0 disables the cache
+    ListingTtl float64
+    // Keep RETR contents in the disk cache
+    ContentCache bool
+    // Seconds cached listings and contents may be served when the upstream fails
+    StaleIfError float64
+    // Overrides of the global httpClient settings, "redirects" can also
+    // be set directly on the mount. Clients are per host, mounts sharing
+    // a host must use the same settings.
+    HttpClient HttpClient
+    // List entries redirecting within the same host as symlinks to the target
+    RedirectLinks bool
+    // Index page format of HTTP mounts, e.g. "nginx" or "apache", empty detects it.
+    // Only "caddy-json" is requested with an Accept header: for "nginx-json"
+    // and "nginx-xml" the upstream must serve that format, by its autoindex
+    // configuration or by an indexQuery mapped to it.
+    Parser string
+    // HEAD each file of HTTP listings for its exact size and time
+    HeadEntries bool
+    // Query string of index page requests, e.g. "format=json" for servers
+    // choosing the autoindex format from it
+    IndexQuery string
+    // Mirror selection: "round-robin", "primary-backup" or "least-connections"
+    Balance string
+    // The circuit breaker of an upstream opens for failTimeout seconds
+    // after maxFails failures in a row
+    MaxFails int
+    FailTimeout float64
+    // Failed requests are retried after retryBackoff seconds, doubling
+    // up to retryBackoffMax, with random jitter
+    Retries int
+    RetryBackoff float64
+    RetryBackoffMax float64
+    // Path polled every healthInterval seconds on each mirror, empty disables
+    HealthCheck string
+    HealthInterval float64
+    // RETR of files from segmentThreshold bytes as `segments` parallel
+    // ranges of segmentSize bytes, 0 or 1 segment or a segmentSize of 0
+    // disables it
+    Segments int
+    SegmentSize int64
+    SegmentThreshold int64
 }
 
 var conf Cfg
@@ -30,19 +120,156 @@ func LoadConfig(filePath string) {
     conf.DefaultVhost = f["defaultHttpIp"].(string)
     conf.ListenPort = f["listenPort"].(string)
     conf.Vhosts = f["httpIps"].(map[string]interface{})
+    conf.AdminListen = getString(f, "adminListen", "")
+    conf.ListingCacheEntries = int(getNumber(f, "listingCacheEntries", 1000))
+    conf.ContentCacheDir = getString(f, "contentCacheDir", "")
+    conf.ContentCacheSize = int64(getNumber(f, "contentCacheSize", 10 * 1024 * 1024 * 1024))
+    conf.ContentCacheFills = int(getNumber(f, "contentCacheFills", 4))
+    conf.HttpClient = parseHttpClient(f, HttpClient{
+        DialTimeout: 10,
+        TlsTimeout: 10,
+        ResponseHeaderTimeout: 30,
+        ReadTimeout: 60,
+        IdleTimeout: 90,
+        MaxIdleConns: 8,
+        MaxConnsPerHost: 0,
+        Http2: true,
+        Proxy: "",
+        Redirects: "follow",
+    })
+}
+
+// Return the "httpClient" settings of options, missing ones from defaults
+func parseHttpClient(options map[string]interface{}, defaults HttpClient) (HttpClient) {
+    values, _ := options["httpClient"].(map[string]interface{})
+    return HttpClient{
+        DialTimeout: getNumber(values, "dialTimeout", defaults.DialTimeout),
+        TlsTimeout: getNumber(values, "tlsTimeout", defaults.TlsTimeout),
+        ResponseHeaderTimeout: getNumber(values, "responseHeaderTimeout", defaults.ResponseHeaderTimeout),
+        ReadTimeout: getNumber(values, "readTimeout", defaults.ReadTimeout),
+        IdleTimeout: getNumber(values, "idleTimeout", defaults.IdleTimeout),
+        MaxIdleConns: int(getNumber(values, "maxIdleConns", float64(defaults.MaxIdleConns))),
+        MaxConnsPerHost: int(getNumber(values, "maxConnsPerHost", float64(defaults.MaxConnsPerHost))),
+        Http2: getBool(values, "http2", defaults.Http2),
+        Proxy: getString(values, "proxy", defaults.Proxy),
+        Redirects: getString(values, "redirects", defaults.Redirects),
+    }
 }
 
 // Return the vhost for the given path (either dir or file)
 func GetVhost(path string) (string) {
     for pathPrefix, vhost := range conf.Vhosts {
         if strings.HasPrefix(path + "/", pathPrefix + "/") {
-            return vhost.(string)
+            return parseMount(pathPrefix, vhost).Host
         }
     }
     fmt.Printf("WARNING! No vhost found for path: %s, using default vhost\n", path)
     return conf.DefaultVhost
 }
 
+// Return the mount for the given path (either dir or file)
+func GetMount(path string) (Mount) {
+    for pathPrefix, vhost := range conf.Vhosts {
+        if strings.HasPrefix(path + "/", pathPrefix + "/") {
+            return parseMount(pathPrefix, vhost)
+        }
+    }
+    fmt.Printf("WARNING! No mount found for path: %s, using default vhost\n", path)
+    return parseMount("", conf.DefaultVhost)
+}
+
+func parseMount(prefix string, value interface{}) (Mount) {
+    var vhost string
+    options := map[string]interface{}{}
+    switch v := value.(type) {
+    case string:
+        vhost = v
+    case map[string]interface{}:
+        options = v
+        vhost, _ = options["url"].(string)
+    default:
+        fmt.Printf("WARNING! Invalid mount for %s\n", prefix)
+    }
+    // Mirrors after the first one
+    var mirrors []interface{}
+    if urls, ok := options["url"].([]interface{}); ok && len(urls) > 0 {
+        vhost, _ = urls[0].(string)
+        mirrors = urls[1:]
+    }
+    mount := Mount{Prefix: strings.TrimRight(prefix, "/"), Scheme: "http", Host: vhost}
+    mount.Region = getString(options, "region", "us-east-1")
+    mount.AccessKey = getString(options, "accessKey", "")
+    mount.SecretKey = getString(options, "secretKey", "")
+    mount.Archives = getBool(options, "archives", false)
+    mount.DirArchives = getBool(options, "dirArchives", false)
+    mount.ArchiveMaxSize = int64(getNumber(options, "archiveMaxSize", 4 * 1024 * 1024 * 1024))
+    mount.ArchiveMaxDepth = int(getNumber(options, "archiveMaxDepth", 8))
+    mount.Decompress = getBool(options, "decompress", false)
+    mount.ListingTtl = getNumber(options, "listingTtl", 10)
+    mount.ContentCache = getBool(options, "contentCache", false)
+    mount.StaleIfError = getNumber(options, "staleIfError", 0)
+    mount.HttpClient = parseHttpClient(options, conf.HttpClient)
+    mount.HttpClient.Redirects = getString(options, "redirects", mount.HttpClient.Redirects)
+    mount.RedirectLinks = getBool(options, "redirectLinks", false)
+    mount.Parser = getString(options, "parser", "")
+    mount.IndexQuery = getString(options, "indexQuery", "")
+    mount.HeadEntries = getBool(options, "headEntries", false)
+    mount.Balance = getString(options, "balance", "round-robin")
+    mount.MaxFails = int(getNumber(options, "maxFails", 3))
+    mount.FailTimeout = getNumber(options, "failTimeout", 30)
+    mount.Retries = int(getNumber(options, "retries", 2))
+    mount.RetryBackoff = getNumber(options, "retryBackoff", 0.2)
+    mount.RetryBackoffMax = getNumber(options, "retryBackoffMax", 5)
+    mount.HealthCheck = getString(options, "healthCheck", "")
+    mount.HealthInterval = getNumber(options, "healthInterval", 10)
+    mount.Segments = int(getNumber(options, "segments", 0))
+    mount.SegmentSize = int64(getNumber(options, "segmentSize", 4 * 1024 * 1024))
+    mount.SegmentThreshold = int64(getNumber(options, "segmentThreshold", 32 * 1024 * 1024))
+    if mount.SegmentSize <= 0 {
+        mount.Segments = 0
+    }
+    mount.Hosts = []string{mount.Host}
+    if !strings.Contains(vhost, "://") {
+        for _, mirror := range mirrors {
+            if host, ok := mirror.(string); ok {
+                mount.Hosts = append(mount.Hosts, host)
+            }
+        }
+        return mount
+    }
+    u, err := url.Parse(vhost)
+    if err != nil {
+        fmt.Printf("WARNING! Invalid mount url: %s, %s\n", vhost, err.Error())
+        return mount
+    }
+    mount.Scheme = strings.ToLower(u.Scheme)
+    mount.Host = u.Host
+    mount.Path = u.Path
+    if u.User != nil {
+        mount.User = u.User.Username()
+        mount.Password, _ = u.User.Password()
+    }
+    mount.Hosts = []string{mount.Host}
+    for _, mirror := range mirrors {
+        mirrorUrl, _ := mirror.(string)
+        if m, err := url.Parse(mirrorUrl); err == nil && m.Host != "" {
+            mount.Hosts = append(mount.Hosts, m.Host)
+        } else {
+            fmt.Printf("WARNING! Invalid mirror url: %s\n", mirrorUrl)
+        }
+    }
+    return mount
+}
+
+// Return all configured mounts
+func GetMounts() ([]Mount) {
+    var mounts []Mount
+    for pathPrefix, vhost := range conf.Vhosts {
+        mounts = append(mounts, parseMount(pathPrefix, vhost))
+    }
+    return mounts
+}
+
 func GetVhosts() (map[string]interface{}) {
     return conf.Vhosts
 }
@@ -54,3 +281,48 @@ func GetListenPort() (string) {
 func GetMaxConnections() (int) {
     return conf.MaxConnections
 }
+
+func GetAdminListen() (string) {
+    return conf.AdminListen
+}
+
+func GetListingCacheEntries() (int) {
+    return conf.ListingCacheEntries
+}
+
+// Return directory, size in bytes and maximum concurrent fills of the content cache
+func GetContentCache() (string, int64, int) {
+    return conf.ContentCacheDir, conf.ContentCacheSize, conf.ContentCacheFills
+}
+
+// Return the global upstream HTTP settings
+func GetHttpClient() (HttpClient) {
+    return conf.HttpClient
+}
+
+// Return options[key] if it is a string, defaultValue otherwise
+func getString(options map[string]interface{}, key string, defaultValue string) (string) {
+    value, ok := options[key].(string)
+    if ok != true {
+        return defaultValue
+    }
+    return value
+}
+
+// Return options[key] if it is a boolean, defaultValue otherwise
+func getBool(options map[string]interface{}, key string, defaultValue bool) (bool) {
+    value, ok := options[key].(bool)
+    if ok != true {
+        return defaultValue
+    }
+    return value
+}
+
+// Return options[key] if it is a number, defaultValue otherwise
+func getNumber(options map[string]interface{}, key string, defaultValue float64) (float64) {
+    value, ok := options[key].(float64)
+    if ok != true {
+        return defaultValue
+    }
+    return value
+}
@@ -0,0 +1,319 @@
+package contentcache
+
+// On-disk cache of file contents, keyed by upstream URL, with an LRU size cap.
+// Each file is stored as <sha256 of url>.data with its validators in a .meta
+// JSON file. Files are filled while they are sent to a client and renamed in
+// place only once complete.
+
+import (
+    "container/list"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "metrics"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Validators of a cached file
+type Meta struct {
+    Url string
+    ETag string
+    LastModified string
+    Size int64
+    Validated time.Time     // Last time the validators matched upstream
+}
+
+type entry struct {
+    meta Meta
+    name string          // File name without extension
+    lastAccess time.Time
+    elem *list.Element
+}
+
+type Cache struct {
+    sync.Mutex
+    dir string
+    maxSize int64
+    totalSize int64
+    entries map[string]*entry   // By url
+    lru *list.List              // Most recently used first
+    filling map[string]bool
+    fills chan struct{}         // Semaphore on concurrent fills
+}
+
+var cache *Cache
+
+func fileName(url string) (string) {
+    sum := sha256.Sum256([]byte(url))
+    return hex.EncodeToString(sum[:])
+}
+
+// Set up the cache in dir, loading what is already there
+func Init(dir string, maxSize int64, maxFills int) (error) {
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return err
+    }
+    c := &Cache{
+        dir: dir,
+        maxSize: maxSize,
+        entries: make(map[string]*entry),
+        lru: list.New(),
+        filling: make(map[string]bool),
+        fills: make(chan struct{}, maxFills),
+    }
+    // Leftovers of interrupted fills
+    tmpFiles, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+    for _, tmpFile := range tmpFiles {
+        os.Remove(tmpFile)
+    }
+    metaFiles, _ := filepath.Glob(filepath.Join(dir, "*.meta"))
+    var loaded []*entry
+    for _, metaFile := range metaFiles {
+        name := strings.TrimSuffix(filepath.Base(metaFile), ".meta")
+        var meta Meta
+        data, err := os.ReadFile(metaFile)
+        if err == nil {
+            err = json.Unmarshal(data, &meta)
+        }
+        info, statErr := os.Stat(filepath.Join(dir, name + ".data"))
+        if err != nil || statErr != nil || info.Size() != meta.Size || fileName(meta.Url) != name {
+            os.Remove(metaFile)
+            os.Remove(filepath.Join(dir, name + ".data"))
+            continue
+        }
+        // The data file mtime is bumped on access
+        loaded = append(loaded, &entry{meta: meta, name: name, lastAccess: info.ModTime()})
+    }
+    sort.Slice(loaded, func(i, j int) bool {
+        return loaded[i].lastAccess.After(loaded[j].lastAccess)
+    })
+    for _, e := range loaded {
+        e.elem = c.lru.PushBack(e)
+        c.entries[e.meta.Url] = e
+        c.totalSize += e.meta.Size
+    }
+    fmt.Printf("Content cache: %d files, %d bytes in %s\n", len(c.entries), c.totalSize, dir)
+    cache = c
+    c.Lock()
+    c.evict()
+    c.Unlock()
+    return nil
+}
+
+func Enabled() (bool) {
+    return cache != nil
+}
+
+// Return the validators of a cached file
+func Lookup(url string) (Meta, bool) {
+    if cache == nil {
+        return Meta{}, false
+    }
+    cache.Lock()
+    defer cache.Unlock()
+    e, exists := cache.entries[url]
+    if exists != true {
+        return Meta{}, false
+    }
+    return e.meta, true
+}
+
+// Open a cached file at offset
+func Open(url string, offset int64) (*os.File, error) {
+    if cache == nil {
+        return nil, errors.New("content cache disabled")
+    }
+    cache.Lock()
+    e, exists := cache.entries[url]
+    if exists != true {
+        cache.Unlock()
+        return nil, errors.New("not in content cache")
+    }
+    e.lastAccess = time.Now()
+    cache.lru.MoveToFront(e.elem)
+    dataPath := filepath.Join(cache.dir, e.name + ".data")
+    cache.Unlock()
+
+    os.Chtimes(dataPath, e.lastAccess, e.lastAccess)
+    file, err := os.Open(dataPath)
+    if err != nil {
+        Remove(url)
+        return nil, err
+    }
+    if _, err = file.Seek(offset, io.SeekStart); err != nil {
+        file.Close()
+        return nil, err
+    }
+    return file, nil
+}
+
+// Record that a cached file still matches upstream
+func Revalidate(url string) {
+    if cache == nil {
+        return
+    }
+    cache.Lock()
+    defer cache.Unlock()
+    e, exists := cache.entries[url]
+    if exists != true {
+        return
+    }
+    e.meta.Validated = time.Now()
+    metaData, _ := json.Marshal(e.meta)
+    metaPath := filepath.Join(cache.dir, e.name + ".meta")
+    if os.WriteFile(metaPath + ".tmp", metaData, 0600) == nil {
+        os.Rename(metaPath + ".tmp", metaPath)
+    }
+}
+
+// Drop a cached file, e.g. when it is stale
+func Remove(url string) {
+    if cache == nil {
+        return
+    }
+    cache.Lock()
+    defer cache.Unlock()
+    if e, exists := cache.entries[url]; exists {
+        cache.remove(e)
+    }
+}
+
+// Must be called locked
+func (c *Cache) remove(e *entry) {
+    c.lru.Remove(e.elem)
+    delete(c.entries, e.meta.Url)
+    c.totalSize -= e.meta.Size
+    os.Remove(filepath.Join(c.dir, e.name + ".meta"))
+    os.Remove(filepath.Join(c.dir, e.name + ".data"))
+}
+
+// Must be called locked
+func (c *Cache) evict() {
+    for c.totalSize > c.maxSize && c.lru.Len() > 0 {
+        oldest := c.lru.Back().Value.(*entry)
+        fmt.Printf("Content cache: evicting %s\n", oldest.meta.Url)
+        metrics.Inc("ftproxy_content_cache_evictions_total")
+        c.remove(oldest)
+    }
+}
+
+// A cache fill in progress
+type Fill struct {
+    meta Meta
+    file *os.File
+    written int64
+    failed bool
+    done bool
+}
+
+// Start filling url, returns nil if the file is already being filled
+// or too many fills are in progress
+func StartFill(meta Meta) (*Fill) {
+    if cache == nil {
+        return nil
+    }
+    cache.Lock()
+    if cache.filling[meta.Url] {
+        cache.Unlock()
+        return nil
+    }
+    select {
+    case cache.fills <- struct{}{}:
+    default:
+        cache.Unlock()
+        fmt.Println("Content cache: too many fills in progress, not caching", meta.Url)
+        return nil
+    }
+    cache.filling[meta.Url] = true
+    cache.Unlock()
+
+    file, err := os.CreateTemp(cache.dir, fileName(meta.Url) + "-*.tmp")
+    if err != nil {
+        fmt.Println("Content cache: cannot create file:", err.Error())
+        endFill(meta.Url)
+        return nil
+    }
+    return &Fill{meta: meta, file: file}
+}
+
+func endFill(url string) {
+    cache.Lock()
+    delete(cache.filling, url)
+    cache.Unlock()
+    <-cache.fills
+}
+
+func (f *Fill) Write(p []byte) {
+    if f.failed || f.done {
+        return
+    }
+    n, err := f.file.Write(p)
+    f.written += int64(n)
+    if err != nil {
+        fmt.Println("Content cache: write failed:", err.Error())
+        f.failed = true
+    }
+}
+
+// Keep the file if it was written in full, drop it otherwise
+func (f *Fill) Finish(complete bool) {
+    if f.done {
+        return
+    }
+    f.done = true
+    defer endFill(f.meta.Url)
+    tmpPath := f.file.Name()
+    if f.meta.Size >= 0 && f.written != f.meta.Size {
+        // Validators announced another size, do not trust this copy
+        complete = false
+    }
+    if complete != true || f.failed || f.file.Sync() != nil {
+        f.file.Close()
+        os.Remove(tmpPath)
+        return
+    }
+    f.file.Close()
+    f.meta.Size = f.written
+    f.meta.Validated = time.Now()
+
+    name := fileName(f.meta.Url)
+    metaData, _ := json.Marshal(f.meta)
+    metaPath := filepath.Join(cache.dir, name + ".meta")
+    dataPath := filepath.Join(cache.dir, name + ".data")
+
+    cache.Lock()
+    defer cache.Unlock()
+    if old, exists := cache.entries[f.meta.Url]; exists {
+        cache.remove(old)
+    }
+    if f.written > cache.maxSize {
+        os.Remove(tmpPath)
+        return
+    }
+    if err := os.WriteFile(metaPath + ".tmp", metaData, 0600); err != nil {
+        os.Remove(tmpPath)
+        return
+    }
+    // Data first: a .meta without .data is dropped at startup
+    if os.Rename(tmpPath, dataPath) != nil || os.Rename(metaPath + ".tmp", metaPath) != nil {
+        os.Remove(tmpPath)
+        os.Remove(dataPath)
+        os.Remove(metaPath + ".tmp")
+        return
+    }
+    e := &entry{meta: f.meta, name: name, lastAccess: time.Now()}
+    e.elem = cache.lru.PushFront(e)
+    cache.entries[f.meta.Url] = e
+    cache.totalSize += f.written
+    cache.evict()
+    metrics.Inc("ftproxy_content_cache_fills_total")
+    fmt.Printf("Content cache: stored %s, %d bytes\n", f.meta.Url, f.written)
+}
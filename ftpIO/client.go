@@ -0,0 +1,145 @@
+package ftpIO
+
+// Upstream HTTP clients. One client, and so one connection pool, is built
+// per distinct set of settings and shared by all the hosts using it.
+
+import (
+    "cfg"
+    "context"
+    "crypto/tls"
+    "errors"
+    "fmt"
+    "net"
+    "net/http"
+    "net/url"
+    "sync"
+    "time"
+)
+
+var clients = struct {
+    sync.Mutex
+    bySettings map[cfg.HttpClient]*http.Client
+    byHost map[string]cfg.HttpClient
+}{
+    bySettings: make(map[cfg.HttpClient]*http.Client),
+    byHost: make(map[string]cfg.HttpClient),
+}
+
+func seconds(s float64) (time.Duration) {
+    return time.Duration(s * float64(time.Second))
+}
+
+// A connection whose reads fail after timeout without data.
+// Pooled idle connections are also dropped after timeout.
+type readTimeoutConn struct {
+    net.Conn
+    timeout time.Duration
+}
+
+func (c *readTimeoutConn) Read(p []byte) (int, error) {
+    c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+    return c.Conn.Read(p)
+}
+
+func newClient(settings cfg.HttpClient) (*http.Client) {
+    dialer := &net.Dialer{Timeout: seconds(settings.DialTimeout), KeepAlive: 30 * time.Second}
+    transport := &http.Transport{
+        Proxy: http.ProxyFromEnvironment,
+        DialContext: dialer.DialContext,
+        TLSHandshakeTimeout: seconds(settings.TlsTimeout),
+        ResponseHeaderTimeout: seconds(settings.ResponseHeaderTimeout),
+        IdleConnTimeout: seconds(settings.IdleTimeout),
+        MaxIdleConns: settings.MaxIdleConns,
+        MaxIdleConnsPerHost: settings.MaxIdleConns,
+        MaxConnsPerHost: settings.MaxConnsPerHost,
+        ForceAttemptHTTP2: settings.Http2,
+    }
+    if settings.ReadTimeout > 0 {
+        transport.DialContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+            conn, err := dialer.DialContext(ctx, network, addr)
+            if err != nil {
+                return nil, err
+            }
+            return &readTimeoutConn{Conn: conn, timeout: seconds(settings.ReadTimeout)}, nil
+        }
+    }
+    if settings.Http2 != true {
+        // A non-nil empty map disables HTTP/2
+        transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+    }
+    if settings.Proxy != "" {
+        proxyUrl, err := url.Parse(settings.Proxy)
+        if err != nil {
+            fmt.Printf("WARNING! Invalid proxy url: %s, %s\n", settings.Proxy, err.Error())
+        } else {
+            transport.Proxy = http.ProxyURL(proxyUrl)
+        }
+    }
+    return &http.Client{Transport: transport, CheckRedirect: redirectPolicy(settings.Redirects)}
+}
+
+// Return the CheckRedirect of a redirects setting. A redirect not followed
+// is returned as the response, and fails as an unexpected status.
+func redirectPolicy(redirects string) (func(*http.Request, []*http.Request) error) {
+    switch redirects {
+    case "refuse":
+        return func(req *http.Request, via []*http.Request) (error) {
+            fmt.Printf("Not following redirect to %s\n", req.URL)
+            return http.ErrUseLastResponse
+        }
+    case "same-host":
+        return func(req *http.Request, via []*http.Request) (error) {
+            if req.URL.Host != via[0].URL.Host {
+                fmt.Printf("Not following redirect to other host: %s\n", req.URL)
+                return http.ErrUseLastResponse
+            }
+            if len(via) >= 10 {
+                return errors.New("stopped after 10 redirects")
+            }
+            return nil
+        }
+    case "follow", "":
+    default:
+        fmt.Printf("WARNING! Unknown redirects policy: %s, following them\n", redirects)
+    }
+    // Default policy of http.Client
+    return nil
+}
+
+// Return the client for settings, built on first use
+func Client(settings cfg.HttpClient) (*http.Client) {
+    clients.Lock()
+    defer clients.Unlock()
+    client, exists := clients.bySettings[settings]
+    if exists != true {
+        client = newClient(settings)
+        clients.bySettings[settings] = client
+    }
+    return client
+}
+
+// Use settings for all requests to host. Requests only carry the host,
+// so settings differing from an earlier call are an error.
+func SetHostClient(host string, settings cfg.HttpClient) (error) {
+    clients.Lock()
+    defer clients.Unlock()
+    if old, exists := clients.byHost[host]; exists && old != settings {
+        if old.Redirects != settings.Redirects {
+            return fmt.Errorf("mounts of %s have different redirects policies, %s and %s", host, old.Redirects, settings.Redirects)
+        }
+        return fmt.Errorf("mounts of %s have different httpClient settings", host)
+    }
+    clients.byHost[host] = settings
+    return nil
+}
+
+// Return the client for host, with the global settings if none was set
+func HostClient(host string) (*http.Client) {
+    clients.Lock()
+    settings, exists := clients.byHost[host]
+    clients.Unlock()
+    if exists != true {
+        settings = cfg.GetHttpClient()
+    }
+    return Client(settings)
+}
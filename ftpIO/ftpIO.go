@@ -1,10 +1,15 @@
 package ftpIO
 
 import (
+    "context"
+    "errors"
     "fmt"
     "net"
     "net/http"
+    "net/url"
     "io"
+    "strconv"
+    "strings"
 )
 
 func Close(conn net.Conn) {
@@ -38,36 +43,292 @@ func CheckUrl(httpIp string, filePath string) (bool) {
 }
 */
 
-func OpenUrl(httpIp string, filePath string, resp **http.Response) (bool) {
-    if (resp == nil) {
-        return false
+// A failed upstream HTTP request
+type UrlError struct {
+    Url string
+    StatusCode int         // 0 if the server did not answer
+    Err error              // Why the server did not answer
+}
+
+func (e *UrlError) Error() (string) {
+    if e.StatusCode != 0 {
+        return fmt.Sprintf("%s: HTTP %d", e.Url, e.StatusCode)
     }
+    return fmt.Sprintf("%s: %s", e.Url, e.Err.Error())
+}
 
-    url := fmt.Sprintf("http://%s%s", httpIp, filePath)
-    fmt.Printf("Opening url: %s\n", url)
+func (e *UrlError) Unwrap() (error) {
+    return e.Err
+}
 
+// Send req, the response must have one of the accepted status codes
+func doUrl(httpIp string, req *http.Request, resp **http.Response, accepted ...int) (error) {
     var err error
-    *resp, err = http.Get(url)
-    if err != nil || resp == nil || (*resp).StatusCode != 200 {
-        fmt.Printf("Error trying to GET url: %s\n", url)
+    *resp, err = HostClient(httpIp).Do(req)
+    if err != nil {
+        fmt.Printf("Error trying to %s url: %s, %s\n", req.Method, req.URL, err.Error())
+        return &UrlError{Url: req.URL.String(), Err: err}
+    }
+    for _, status := range accepted {
+        if (*resp).StatusCode == status {
+            if req.Method == "GET" && status != 304 {
+                (*resp).Body = VerifyBody(*resp)
+            }
+            return nil
+        }
+    }
+    fmt.Printf("Error trying to %s url: %s, HTTP %d\n", req.Method, req.URL, (*resp).StatusCode)
+    CloseUrl(*resp)
+    return &UrlError{Url: req.URL.String(), StatusCode: (*resp).StatusCode}
+}
+
+func newRequest(method string, httpIp string, filePath string) (*http.Request, error) {
+    url := fmt.Sprintf("http://%s%s", httpIp, filePath)
+    req, err := http.NewRequest(method, url, nil)
+    if err != nil {
+        fmt.Printf("Error trying to %s url: %s\n", method, url)
+        return nil, &UrlError{Url: url, Err: err}
+    }
+    return req, nil
+}
+
+func OpenUrl(httpIp string, filePath string, resp **http.Response) (error) {
+    req, err := newRequest("GET", httpIp, filePath)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Opening url: %s\n", req.URL)
+    /* do not forget to CloseUrl() on success */
+    return doUrl(httpIp, req, resp, 200)
+}
+
+// Same as OpenUrl(), revalidating a cached copy with its ETag and
+// Last-Modified. A 304 also succeeds, its response has no body to close.
+// A non-empty accept is sent as the Accept header.
+func OpenUrlIf(httpIp string, filePath string, accept string, etag string, lastModified string, resp **http.Response) (error) {
+    req, err := newRequest("GET", httpIp, filePath)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Opening url: %s if changed\n", req.URL)
+    if accept != "" {
+        req.Header.Set("Accept", accept)
+    }
+    if etag != "" {
+        req.Header.Set("If-None-Match", etag)
+    }
+    if lastModified != "" {
+        req.Header.Set("If-Modified-Since", lastModified)
+    }
+    if err = doUrl(httpIp, req, resp, 200, 304); err != nil {
+        return err
+    }
+    if (*resp).StatusCode == 304 {
         CloseUrl(*resp)
-        return false
     }
     /* do not forget to CloseUrl() from here */
-    return true
+    return nil
+}
+
+// A file no longer matching the version a transfer started on
+var ErrChanged = errors.New("file changed upstream")
+
+// Return the validator to send as If-Range for the file of resp. Mirrors
+// keep modification times but have their own ETags (Apache puts the inode
+// in them), so Last-Modified comes first. Weak ETags never match.
+func IfRangeValidator(header http.Header) (string) {
+    if lastModified := header.Get("Last-Modified"); lastModified != "" {
+        return lastModified
+    }
+    if etag := header.Get("ETag"); strings.HasPrefix(etag, "W/") != true {
+        return etag
+    }
+    return ""
+}
+
+// Return the size of the whole file resp is from, -1 if unknown
+func FileSize(resp *http.Response) (int64) {
+    if resp.StatusCode != 206 {
+        return resp.ContentLength
+    }
+    // "bytes first-last/total"
+    pieces := strings.SplitN(resp.Header.Get("Content-Range"), "/", 2)
+    if len(pieces) != 2 {
+        return -1
+    }
+    size, err := strconv.ParseInt(strings.TrimSpace(pieces[1]), 10, 64)
+    if err != nil {
+        return -1
+    }
+    return size
+}
+
+// Same as OpenUrl(), with the body starting at offset (for REST)
+func OpenUrlFrom(httpIp string, filePath string, offset int64, resp **http.Response) (error) {
+    return OpenUrlFromIf(httpIp, filePath, offset, "", resp)
+}
+
+// Same as OpenUrlFrom(), only if the file is still the version ifRange
+// (from IfRangeValidator()) names. Fails with ErrChanged if it is not.
+func OpenUrlFromIf(httpIp string, filePath string, offset int64, ifRange string, resp **http.Response) (error) {
+    if offset == 0 {
+        return OpenUrl(httpIp, filePath, resp)
+    }
+    req, err := newRequest("GET", httpIp, filePath)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Opening url: %s from offset %d\n", req.URL, offset)
+    req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+    if ifRange != "" {
+        req.Header.Set("If-Range", ifRange)
+    }
+    if err = doUrl(httpIp, req, resp, 200, 206); err != nil {
+        return err
+    }
+    if (*resp).StatusCode == 200 && ifRange != "" && IfRangeValidator((*resp).Header) != ifRange {
+        // If-Range did not match, the whole new version was sent
+        fmt.Printf("File changed at url: %s\n", req.URL)
+        CloseUrl(*resp)
+        return &UrlError{Url: req.URL.String(), Err: ErrChanged}
+    }
+    if (*resp).StatusCode == 200 {
+        // Range not supported by the server, skip to offset ourselves
+        _, err = io.CopyN(io.Discard, (*resp).Body, offset)
+        if err != nil {
+            fmt.Printf("Error skipping to offset %d in url: %s\n", offset, req.URL)
+            CloseUrl(*resp)
+            return &UrlError{Url: req.URL.String(), Err: err}
+        }
+    }
+    /* do not forget to CloseUrl() from here */
+    return nil
+}
+
+// Same as OpenUrl(), with only length bytes from offset.
+// Fails if the server does not support ranges.
+func OpenUrlRange(httpIp string, filePath string, offset int64, length int64, resp **http.Response) (error) {
+    return OpenUrlRangeContext(context.Background(), httpIp, filePath, offset, length, "", resp)
+}
+
+// Same as OpenUrlRange(), the request and its body are aborted when ctx is
+// done. A non-empty ifRange is sent as If-Range, a changed file gets a 200
+// and fails like a server without ranges.
+func OpenUrlRangeContext(ctx context.Context, httpIp string, filePath string, offset int64, length int64, ifRange string, resp **http.Response) (error) {
+    req, err := newRequest("GET", httpIp, filePath)
+    if err != nil {
+        return err
+    }
+    req = req.WithContext(ctx)
+    fmt.Printf("Opening url: %s range %d+%d\n", req.URL, offset, length)
+    req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset + length - 1))
+    if ifRange != "" {
+        req.Header.Set("If-Range", ifRange)
+    }
+    /* do not forget to CloseUrl() on success */
+    return doUrl(httpIp, req, resp, 206)
+}
+
+// HEAD an url, the response has no body to close
+func HeadUrl(httpIp string, filePath string, resp **http.Response) (error) {
+    req, err := newRequest("HEAD", httpIp, filePath)
+    if err != nil {
+        return err
+    }
+    if err = doUrl(httpIp, req, resp, 200); err != nil {
+        return err
+    }
+    CloseUrl(*resp)
+    return nil
+}
+
+// Return the URL filePath redirects to, using HEAD without following it,
+// or nil if it does not redirect
+func RedirectTarget(httpIp string, filePath string) (*url.URL, error) {
+    req, err := newRequest("HEAD", httpIp, filePath)
+    if err != nil {
+        return nil, err
+    }
+    client := *HostClient(httpIp)
+    client.CheckRedirect = func(req *http.Request, via []*http.Request) (error) {
+        return http.ErrUseLastResponse
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        fmt.Printf("Error trying to HEAD url: %s, %s\n", req.URL, err.Error())
+        return nil, &UrlError{Url: req.URL.String(), Err: err}
+    }
+    CloseUrl(resp)
+    location := resp.Header.Get("Location")
+    if resp.StatusCode < 300 || resp.StatusCode > 399 || location == "" {
+        return nil, nil
+    }
+    return req.URL.Parse(location)
+}
+
+// Return the Content-Length of an url, using HEAD
+func UrlSize(httpIp string, filePath string) (int64, error) {
+    var resp *http.Response
+    if err := HeadUrl(httpIp, filePath, &resp); err != nil {
+        return 0, err
+    }
+    if resp.ContentLength < 0 {
+        return 0, &UrlError{Url: resp.Request.URL.String(), Err: errors.New("size unknown")}
+    }
+    return resp.ContentLength, nil
 }
 
-func SendUrl(conn net.Conn, resp *http.Response) (bool) {
+func SendUrl(conn net.Conn, resp *http.Response) (error) {
     if (resp == nil) {
-        return false
+        return errors.New("no response")
+    }
+
+    return Send(conn, resp.Body)
+}
+
+// A RETR that broke after it started
+type TransferError struct {
+    Upstream bool          // The upstream broke, not the client
+    Err error
+}
+
+func (e *TransferError) Error() (string) {
+    if e.Upstream {
+        return "upstream: " + e.Err.Error()
+    }
+    return "client: " + e.Err.Error()
+}
+
+func (e *TransferError) Unwrap() (error) {
+    return e.Err
+}
+
+// Remembers the error of the reader, io.Copy does not tell which side failed
+type errorRecorder struct {
+    r io.Reader
+    err error
+}
+
+func (e *errorRecorder) Read(p []byte) (int, error) {
+    n, err := e.r.Read(p)
+    if err != nil && err != io.EOF {
+        e.err = err
+    }
+    return n, err
+}
+
+func Send(conn net.Conn, r io.Reader) (error) {
+    if (r == nil) {
+        return errors.New("nothing to send")
     }
 
-    _, err := io.Copy(conn, resp.Body)
+    recorder := &errorRecorder{r: r}
+    _, err := io.Copy(conn, recorder)
     if err != nil {
         fmt.Println("Error copying file (for RETR):", err.Error())
-        return false
+        return &TransferError{Upstream: recorder.err != nil, Err: err}
     }
-    return true
+    return nil
 }
 
 func CloseUrl(resp *http.Response) (bool) {
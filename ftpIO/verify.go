@@ -0,0 +1,184 @@
+package ftpIO
+
+// Checks of upstream bodies: the byte count must match Content-Length or
+// Content-Range, and full bodies must match the checksums the upstream
+// announced in Content-MD5, Digest, Content-Digest/Repr-Digest or
+// x-amz-checksum-* headers. A mismatch fails the last Read.
+
+import (
+    "bytes"
+    "crypto/md5"
+    "crypto/sha1"
+    "crypto/sha256"
+    "crypto/sha512"
+    "encoding/base64"
+    "encoding/hex"
+    "fmt"
+    "hash"
+    "hash/crc32"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+// Upstream data not matching its announced checksum
+type IntegrityError struct {
+    Url string
+    Reason string
+}
+
+func (e *IntegrityError) Error() (string) {
+    return fmt.Sprintf("%s: %s", e.Url, e.Reason)
+}
+
+type checksum struct {
+    name string
+    hash hash.Hash
+    expected []byte
+}
+
+type verifiedBody struct {
+    body io.ReadCloser
+    url string
+    expectedSize int64             // -1 if unknown
+    size int64
+    checksums []checksum
+}
+
+// Return the number of body bytes resp announces, -1 if unknown
+func expectedBodySize(resp *http.Response) (int64) {
+    if resp.ContentLength >= 0 {
+        return resp.ContentLength
+    }
+    // "bytes first-last/total"
+    contentRange := strings.TrimPrefix(resp.Header.Get("Content-Range"), "bytes ")
+    span := strings.SplitN(strings.SplitN(contentRange, "/", 2)[0], "-", 2)
+    if resp.StatusCode != 206 || len(span) != 2 {
+        return -1
+    }
+    first, err1 := strconv.ParseInt(span[0], 10, 64)
+    last, err2 := strconv.ParseInt(span[1], 10, 64)
+    if err1 != nil || err2 != nil || last < first {
+        return -1
+    }
+    return last - first + 1
+}
+
+func newHash(name string) (hash.Hash) {
+    switch name {
+    case "md5":
+        return md5.New()
+    case "sha", "sha1":
+        return sha1.New()
+    case "sha-256", "sha256":
+        return sha256.New()
+    case "sha-512", "sha512":
+        return sha512.New()
+    case "crc32":
+        return crc32.NewIEEE()
+    case "crc32c":
+        return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+    }
+    return nil
+}
+
+func isHex(value string) (bool) {
+    for _, c := range value {
+        if strings.ContainsRune("0123456789abcdefABCDEF", c) != true {
+            return false
+        }
+    }
+    return true
+}
+
+// Decode a checksum of size bytes, ":" delimited in Repr-Digest. Hex is
+// valid base64 too, and a base64 crc32 ("AAAAAA==") has the length of
+// its hex form, so hex digests need both the length and the digits.
+func decodeChecksum(value string, size int) ([]byte) {
+    value = strings.Trim(strings.TrimSpace(value), ":\"")
+    var decoded []byte
+    var err error
+    if len(value) == 2 * size && isHex(value) {
+        decoded, err = hex.DecodeString(value)
+    } else {
+        decoded, err = base64.StdEncoding.DecodeString(value)
+    }
+    if err != nil || len(decoded) != size {
+        return nil
+    }
+    return decoded
+}
+
+// Return the checksums announced for the full body of resp
+func announcedChecksums(resp *http.Response) ([]checksum) {
+    var checksums []checksum
+    add := func(name string, value string) {
+        h := newHash(strings.ToLower(name))
+        if h == nil {
+            return
+        }
+        expected := decodeChecksum(value, h.Size())
+        if expected == nil {
+            return
+        }
+        checksums = append(checksums, checksum{name: strings.ToLower(name), hash: h, expected: expected})
+    }
+    if value := resp.Header.Get("Content-MD5"); value != "" {
+        add("md5", value)
+    }
+    for _, header := range []string{"Digest", "Content-Digest", "Repr-Digest"} {
+        for _, digest := range strings.Split(resp.Header.Get(header), ",") {
+            pieces := strings.SplitN(strings.TrimSpace(digest), "=", 2)
+            if len(pieces) == 2 {
+                add(pieces[0], pieces[1])
+            }
+        }
+    }
+    for _, name := range []string{"crc32", "crc32c", "sha1", "sha256"} {
+        value := resp.Header.Get("x-amz-checksum-" + name)
+        // Checksums of multipart objects ("<checksum>-<parts>") are not of the data
+        if value != "" && strings.Contains(value, "-") != true {
+            add(name, value)
+        }
+    }
+    return checksums
+}
+
+// Wrap the body of resp to check its size and checksums when it ends
+func VerifyBody(resp *http.Response) (io.ReadCloser) {
+    v := &verifiedBody{body: resp.Body, url: resp.Request.URL.String(), expectedSize: expectedBodySize(resp)}
+    if resp.StatusCode == 200 && resp.Uncompressed != true {
+        v.checksums = announcedChecksums(resp)
+    }
+    return v
+}
+
+func (v *verifiedBody) Read(p []byte) (int, error) {
+    n, err := v.body.Read(p)
+    v.size += int64(n)
+    for _, c := range v.checksums {
+        c.hash.Write(p[:n])
+    }
+    if v.expectedSize >= 0 && v.size > v.expectedSize {
+        return n, fmt.Errorf("upstream sent more than %d bytes", v.expectedSize)
+    }
+    if err != io.EOF {
+        return n, err
+    }
+    if v.expectedSize >= 0 && v.size != v.expectedSize {
+        fmt.Printf("Truncated body from %s: %d of %d bytes\n", v.url, v.size, v.expectedSize)
+        return n, fmt.Errorf("upstream sent %d of %d bytes", v.size, v.expectedSize)
+    }
+    for _, c := range v.checksums {
+        if bytes.Equal(c.hash.Sum(nil), c.expected) != true {
+            fmt.Printf("Checksum mismatch for %s: %s\n", v.url, c.name)
+            return n, &IntegrityError{Url: v.url, Reason: c.name + " checksum mismatch"}
+        }
+    }
+    return n, err
+}
+
+func (v *verifiedBody) Close() (error) {
+    return v.body.Close()
+}
@@ -0,0 +1,118 @@
+package ftpIO
+
+import (
+    "crypto/md5"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/hex"
+    "errors"
+    "hash/crc32"
+    "io"
+    "net/http"
+    "net/url"
+    "strings"
+    "testing"
+)
+
+var testBody = strings.Repeat("0123456789", 10)
+
+func testResponse(status int, header http.Header, body string, contentLength int64) (*http.Response) {
+    u, _ := url.Parse("http://upstream.test/file")
+    return &http.Response{
+        StatusCode: status,
+        Header: header,
+        Body: io.NopCloser(strings.NewReader(body)),
+        ContentLength: contentLength,
+        Request: &http.Request{URL: u},
+    }
+}
+
+func TestDecodeChecksum(t *testing.T) {
+    sum := sha256.Sum256([]byte(testBody))
+    hexSum := hex.EncodeToString(sum[:])
+    b64Sum := base64.StdEncoding.EncodeToString(sum[:])
+    crc := []byte{0xde, 0xad, 0xbe, 0xef}
+    tests := []struct {
+        value string
+        size int
+        want []byte
+    }{
+        {hexSum, sha256.Size, sum[:]},
+        {strings.ToUpper(hexSum), sha256.Size, sum[:]},
+        {b64Sum, sha256.Size, sum[:]},
+        {":" + b64Sum + ":", sha256.Size, sum[:]},
+        {"\"" + hexSum + "\"", sha256.Size, sum[:]},
+        {hexSum[:32], sha256.Size, nil},
+        {"not a checksum", sha256.Size, nil},
+        // A crc32 in base64 has as many characters as in hex
+        {"3q2+7w==", crc32.Size, crc},
+        {"deadbeef", crc32.Size, crc},
+        {"AAAAAA==", crc32.Size, []byte{0, 0, 0, 0}},
+    }
+    for _, test := range tests {
+        got := decodeChecksum(test.value, test.size)
+        if string(got) != string(test.want) {
+            t.Errorf("decodeChecksum(%q) = %x, want %x", test.value, got, test.want)
+        }
+    }
+}
+
+func TestVerifyBody(t *testing.T) {
+    md5Sum := md5.Sum([]byte(testBody))
+    sha256Sum := sha256.Sum256([]byte(testBody))
+    badSum := sha256.Sum256([]byte("x"))
+    crc := make([]byte, 4)
+    binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE([]byte(testBody)))
+    tests := []struct {
+        name string
+        header http.Header
+        body string
+        contentLength int64
+        wantErr bool
+        wantIntegrity bool
+    }{
+        {"no checksum", http.Header{}, testBody, 100, false, false},
+        {"content-md5", http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(md5Sum[:])}}, testBody, 100, false, false},
+        {"digest base64", http.Header{"Digest": {"sha-256=" + base64.StdEncoding.EncodeToString(sha256Sum[:])}}, testBody, 100, false, false},
+        {"digest hex", http.Header{"Digest": {"sha-256=" + hex.EncodeToString(sha256Sum[:])}}, testBody, 100, false, false},
+        {"digest hex mismatch", http.Header{"Digest": {"sha-256=" + hex.EncodeToString(badSum[:])}}, testBody, 100, true, true},
+        {"repr-digest mismatch", http.Header{"Repr-Digest": {"sha-256=:" + base64.StdEncoding.EncodeToString(badSum[:]) + ":"}}, testBody, 100, true, true},
+        {"amz crc32", http.Header{"X-Amz-Checksum-Crc32": {base64.StdEncoding.EncodeToString(crc)}}, testBody, 100, false, false},
+        {"amz crc32 mismatch", http.Header{"X-Amz-Checksum-Crc32": {"AAAAAA=="}}, testBody, 100, true, true},
+        {"amz crc32c mismatch", http.Header{"X-Amz-Checksum-Crc32c": {"AAAAAA=="}}, testBody, 100, true, true},
+        {"amz multipart", http.Header{"X-Amz-Checksum-Crc32": {"AAAAAA==-2"}}, testBody, 100, false, false},
+        {"truncated", http.Header{}, testBody[:50], 100, true, false},
+        {"too long", http.Header{}, testBody, 50, true, false},
+        {"unknown length", http.Header{}, testBody, -1, false, false},
+    }
+    for _, test := range tests {
+        body := VerifyBody(testResponse(200, test.header, test.body, test.contentLength))
+        _, err := io.ReadAll(body)
+        body.Close()
+        if (err != nil) != test.wantErr {
+            t.Errorf("%s: error %v, want error %v", test.name, err, test.wantErr)
+        }
+        var integrityErr *IntegrityError
+        if errors.As(err, &integrityErr) != test.wantIntegrity {
+            t.Errorf("%s: error %v, want integrity error %v", test.name, err, test.wantIntegrity)
+        }
+    }
+}
+
+func TestVerifyBodyPartial(t *testing.T) {
+    // Checksums are of the full body, only the range length is checked
+    badSum := sha256.Sum256([]byte("x"))
+    header := http.Header{
+        "Content-Range": {"bytes 10-49/100"},
+        "Digest": {"sha-256=" + hex.EncodeToString(badSum[:])},
+    }
+    body := VerifyBody(testResponse(206, header, testBody[10:50], -1))
+    if _, err := io.ReadAll(body); err != nil {
+        t.Errorf("partial body: %v", err)
+    }
+    body = VerifyBody(testResponse(206, header, testBody[10:30], -1))
+    if _, err := io.ReadAll(body); err == nil {
+        t.Errorf("truncated partial body: no error")
+    }
+}
@@ -0,0 +1,401 @@
+package ftpclient
+
+import (
+    "fmt"
+    "io"
+    "net"
+    "net/textproto"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    DIAL_TIMEOUT = 10 * time.Second
+    IDLE_TIMEOUT = 60 * time.Second
+    MAX_IDLE = 4
+)
+
+// A negative reply from the upstream server
+type Error struct {
+    Code int
+    Msg string
+}
+
+func (e *Error) Error() (string) {
+    return fmt.Sprintf("%d %s", e.Code, e.Msg)
+}
+
+// One entry of a directory listing
+type Entry struct {
+    Name string
+    IsDir bool
+    Size int64
+    Time time.Time
+}
+
+// A logged-in control connection to an upstream FTP server
+type Conn struct {
+    conn net.Conn
+    text *textproto.Conn
+    host string
+    hasMlsd bool
+    hasEpsv bool
+    lastUsed time.Time
+}
+
+// Idle connections, by "user@host:port"
+type Pool struct {
+    sync.Mutex
+    idle map[string][]*Conn
+}
+
+var pool = Pool{idle: make(map[string][]*Conn)}
+
+func Dial(addr string, user string, password string) (*Conn, error) {
+    if !strings.Contains(addr, ":") {
+        addr = addr + ":21"
+    }
+    netConn, err := net.DialTimeout("tcp", addr, DIAL_TIMEOUT)
+    if err != nil {
+        return nil, err
+    }
+    host, _, _ := net.SplitHostPort(netConn.RemoteAddr().String())
+    c := &Conn{conn: netConn, text: textproto.NewConn(netConn), host: host}
+    if _, _, err = c.readReply(2); err != nil {
+        c.Close()
+        return nil, err
+    }
+    if user == "" {
+        user = "anonymous"
+        password = "ftproxy@"
+    }
+    code, _, err := c.Cmd(0, "USER %s", user)
+    if err == nil && code == 331 {
+        _, _, err = c.Cmd(2, "PASS %s", password)
+    } else if err == nil && code != 230 {
+        err = &Error{Code: code, Msg: "Unexpected reply to USER"}
+    }
+    if err == nil {
+        _, _, err = c.Cmd(2, "TYPE I")
+    }
+    if err != nil {
+        c.Close()
+        return nil, err
+    }
+    // Feature probe, failure only means we fall back to LIST and PASV
+    if _, msg, err := c.Cmd(2, "FEAT"); err == nil {
+        features := strings.ToUpper(msg)
+        c.hasMlsd = strings.Contains(features, "MLSD") || strings.Contains(features, "MLST")
+        c.hasEpsv = strings.Contains(features, "EPSV")
+    }
+    return c, nil
+}
+
+// Get a connection from the pool, or dial a new one
+func Get(addr string, user string, password string) (*Conn, error) {
+    key := user + "@" + addr
+    pool.Lock()
+    for len(pool.idle[key]) > 0 {
+        conns := pool.idle[key]
+        c := conns[len(conns) - 1]
+        pool.idle[key] = conns[:len(conns) - 1]
+        if time.Since(c.lastUsed) > IDLE_TIMEOUT {
+            c.Close()
+            continue
+        }
+        pool.Unlock()
+        // The server may have timed us out, check before use
+        if _, _, err := c.Cmd(2, "NOOP"); err == nil {
+            return c, nil
+        }
+        c.Close()
+        pool.Lock()
+    }
+    pool.Unlock()
+    return Dial(addr, user, password)
+}
+
+// Give a connection back to the pool once the caller is done with it
+func Put(addr string, user string, c *Conn) {
+    key := user + "@" + addr
+    c.lastUsed = time.Now()
+    pool.Lock()
+    if len(pool.idle[key]) < MAX_IDLE {
+        pool.idle[key] = append(pool.idle[key], c)
+        pool.Unlock()
+        return
+    }
+    pool.Unlock()
+    c.Quit()
+}
+
+func (c *Conn) Close() {
+    c.conn.Close()
+}
+
+func (c *Conn) Quit() {
+    c.Cmd(0, "QUIT")
+    c.Close()
+}
+
+func (c *Conn) readReply(expectCode int) (int, string, error) {
+    c.conn.SetReadDeadline(time.Now().Add(IDLE_TIMEOUT))
+    code, msg, err := c.text.ReadResponse(0)
+    if err != nil {
+        return code, msg, err
+    }
+    // 1xx-5xx, expectCode is the first digit, 0 accepts anything
+    if code >= 400 || (expectCode != 0 && code / 100 != expectCode) {
+        return code, msg, &Error{Code: code, Msg: msg}
+    }
+    return code, msg, nil
+}
+
+// Send a command and read its reply, expectCode is the expected first digit
+func (c *Conn) Cmd(expectCode int, format string, args ...interface{}) (int, string, error) {
+    if err := c.text.PrintfLine(format, args...); err != nil {
+        return 0, "", err
+    }
+    return c.readReply(expectCode)
+}
+
+var pasvRe = regexp.MustCompile("([0-9]+),([0-9]+),([0-9]+),([0-9]+),([0-9]+),([0-9]+)")
+var epsvRe = regexp.MustCompile("\\(\\|\\|\\|([0-9]+)\\|\\)")
+
+// Open a passive data connection, EPSV first, then PASV.
+// The address given by the server is ignored in favour of the control
+// connection peer, which also makes it work behind NAT.
+func (c *Conn) openData() (net.Conn, error) {
+    var port int
+    var err error
+    if c.hasEpsv {
+        var msg string
+        _, msg, err = c.Cmd(2, "EPSV")
+        if match := epsvRe.FindStringSubmatch(msg); err == nil && match != nil {
+            port, _ = strconv.Atoi(match[1])
+        }
+    }
+    if port == 0 {
+        var msg string
+        _, msg, err = c.Cmd(2, "PASV")
+        if err != nil {
+            return nil, err
+        }
+        match := pasvRe.FindStringSubmatch(msg)
+        if match == nil {
+            return nil, &Error{Code: 500, Msg: "Cannot parse PASV reply"}
+        }
+        p1, _ := strconv.Atoi(match[5])
+        p2, _ := strconv.Atoi(match[6])
+        port = p1 << 8 + p2
+    }
+    return net.DialTimeout("tcp", net.JoinHostPort(c.host, strconv.Itoa(port)), DIAL_TIMEOUT)
+}
+
+// Reader on a data connection, reads the final reply on Close().
+// The control connection is only reusable if Reusable() returns true afterwards.
+type DataReader struct {
+    c *Conn
+    data net.Conn
+    eof bool
+    failed bool
+}
+
+func (r *DataReader) Read(p []byte) (int, error) {
+    r.data.SetReadDeadline(time.Now().Add(IDLE_TIMEOUT))
+    n, err := r.data.Read(p)
+    if err == io.EOF {
+        r.eof = true
+    } else if err != nil {
+        r.failed = true
+    }
+    return n, err
+}
+
+func (r *DataReader) Close() (error) {
+    r.data.Close()
+    if r.eof != true {
+        // Transfer aborted by us, the control connection state is unknown
+        r.failed = true
+        return nil
+    }
+    if _, _, err := r.c.readReply(2); err != nil {
+        r.failed = true
+        return err
+    }
+    return nil
+}
+
+func (r *DataReader) Reusable() (bool) {
+    return r.eof && r.failed != true
+}
+
+// Start a data transfer command, e.g. "RETR file"
+func (c *Conn) transfer(offset int64, format string, args ...interface{}) (*DataReader, error) {
+    data, err := c.openData()
+    if err != nil {
+        return nil, err
+    }
+    if offset > 0 {
+        if _, _, err = c.Cmd(3, "REST %d", offset); err != nil {
+            data.Close()
+            return nil, err
+        }
+    }
+    if _, _, err = c.Cmd(1, format, args...); err != nil {
+        data.Close()
+        return nil, err
+    }
+    return &DataReader{c: c, data: data}, nil
+}
+
+// Retrieve a file, starting at offset
+func (c *Conn) Retr(filePath string, offset int64) (*DataReader, error) {
+    return c.transfer(offset, "RETR %s", filePath)
+}
+
+// Return the SIZE of filePath, in bytes
+func (c *Conn) Size(filePath string) (int64, error) {
+    _, msg, err := c.Cmd(2, "SIZE %s", filePath)
+    if err != nil {
+        return -1, err
+    }
+    return strconv.ParseInt(strings.TrimSpace(msg), 10, 64)
+}
+
+// Return the MDTM reply for filePath, e.g. "20240102030405"
+func (c *Conn) Modified(filePath string) (string, error) {
+    _, msg, err := c.Cmd(2, "MDTM %s", filePath)
+    if err != nil {
+        return "", err
+    }
+    return strings.TrimSpace(msg), nil
+}
+
+// List a directory, using MLSD when available
+func (c *Conn) List(dirPath string) ([]Entry, error) {
+    command := "LIST"
+    if c.hasMlsd {
+        command = "MLSD"
+    }
+    r, err := c.transfer(0, "%s %s", command, dirPath)
+    if err != nil {
+        return nil, err
+    }
+    listing, err := io.ReadAll(r)
+    if closeErr := r.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        return nil, err
+    }
+    var entries []Entry
+    for _, line := range strings.Split(string(listing), "\n") {
+        line = strings.TrimRight(line, "\r")
+        var entry Entry
+        var ok bool
+        if command == "MLSD" {
+            entry, ok = parseMlsdLine(line)
+        } else {
+            entry, ok = parseListLine(line)
+        }
+        if ok == true {
+            entries = append(entries, entry)
+        }
+    }
+    return entries, nil
+}
+
+// "type=file;size=1234;modify=20170101120000; name"
+func parseMlsdLine(line string) (Entry, bool) {
+    var entry Entry
+    pieces := strings.SplitN(line, " ", 2)
+    if len(pieces) != 2 || pieces[1] == "" {
+        return entry, false
+    }
+    entry.Name = pieces[1]
+    for _, fact := range strings.Split(pieces[0], ";") {
+        keyVal := strings.SplitN(fact, "=", 2)
+        if len(keyVal) != 2 {
+            continue
+        }
+        switch strings.ToLower(keyVal[0]) {
+        case "type":
+            switch strings.ToLower(keyVal[1]) {
+            case "dir":
+                entry.IsDir = true
+            case "file":
+                entry.IsDir = false
+            default:
+                // cdir, pdir, OS specific types
+                return entry, false
+            }
+        case "size":
+            entry.Size, _ = strconv.ParseInt(keyVal[1], 10, 64)
+        case "modify":
+            // Fractional seconds are optional
+            tim, err := time.Parse("20060102150405", strings.SplitN(keyVal[1], ".", 2)[0])
+            if err == nil {
+                entry.Time = tim
+            }
+        }
+    }
+    return entry, true
+}
+
+// "drwxr-xr-x 2 ftp ftp 4096 Jan  2 15:04 name" or "Jan  2  2006 name"
+var unixListRe = regexp.MustCompile("^([-dl])[-rwxsStT]{9}\\S*\\s+\\S+\\s+\\S+\\s+\\S+\\s+([0-9]+)\\s+(\\w{3}\\s+[0-9]{1,2}\\s+(?:[0-9]{1,2}:[0-9]{2}(?::[0-9]{2})?|[0-9]{4}))\\s(.+)$")
+// "01-02-06  03:04PM       <DIR>          name"
+var dosListRe = regexp.MustCompile("^([0-9]{2}-[0-9]{2}-[0-9]{2,4}\\s+[0-9]{2}:[0-9]{2}[AP]M)\\s+(<DIR>|[0-9]+)\\s+(.+)$")
+
+func parseListLine(line string) (Entry, bool) {
+    var entry Entry
+    if match := unixListRe.FindStringSubmatch(line); match != nil {
+        entry.IsDir = match[1] == "d"
+        entry.Size, _ = strconv.ParseInt(match[2], 10, 64)
+        entry.Name = match[4]
+        if match[1] == "l" {
+            // Show symlinks by their name, as a file
+            entry.Name = strings.SplitN(entry.Name, " -> ", 2)[0]
+        }
+        dateText := strings.Join(strings.Fields(match[3]), " ")
+        if strings.Contains(dateText, ":") {
+            // No year means within the last six months, seconds are not standard
+            tim, err := time.Parse("Jan 2 15:04", dateText)
+            if err != nil {
+                tim, err = time.Parse("Jan 2 15:04:05", dateText)
+            }
+            if err == nil {
+                now := time.Now().UTC()
+                tim = tim.AddDate(now.Year(), 0, 0)
+                if tim.After(now.AddDate(0, 0, 1)) {
+                    tim = tim.AddDate(-1, 0, 0)
+                }
+                entry.Time = tim
+            }
+        } else {
+            entry.Time, _ = time.Parse("Jan 2 2006", dateText)
+        }
+    } else if match := dosListRe.FindStringSubmatch(line); match != nil {
+        entry.IsDir = match[2] == "<DIR>"
+        if entry.IsDir != true {
+            entry.Size, _ = strconv.ParseInt(match[2], 10, 64)
+        }
+        entry.Name = match[3]
+        dateText := strings.Join(strings.Fields(match[1]), " ")
+        tim, err := time.Parse("01-02-06 03:04PM", dateText)
+        if err != nil {
+            tim, _ = time.Parse("01-02-2006 03:04PM", dateText)
+        }
+        entry.Time = tim
+    } else {
+        return entry, false
+    }
+    if entry.Name == "." || entry.Name == ".." {
+        return entry, false
+    }
+    return entry, true
+}
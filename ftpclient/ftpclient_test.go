@@ -0,0 +1,95 @@
+package ftpclient
+
+import (
+    "testing"
+    "time"
+)
+
+func date(year int, month time.Month, day int, hour int, min int, sec int) (time.Time) {
+    return time.Date(year, month, day, hour, min, sec, 0, time.UTC)
+}
+
+func TestParseListLine(t *testing.T) {
+    tests := []struct {
+        line string
+        ok bool
+        want Entry
+    }{
+        // Unix
+        {"drwxr-xr-x    2 ftp      ftp          4096 Jan 02  2006 pub", true,
+            Entry{Name: "pub", IsDir: true, Size: 4096, Time: date(2006, time.January, 2, 0, 0, 0)}},
+        {"-rw-r--r--    1 ftp      ftp      12345678 Dec  9  2019 name with spaces.iso", true,
+            Entry{Name: "name with spaces.iso", Size: 12345678, Time: date(2019, time.December, 9, 0, 0, 0)}},
+        {"-rw-r--r--+   1 1000     1000            5 Jun 30  2001 acl", true,
+            Entry{Name: "acl", Size: 5, Time: date(2001, time.June, 30, 0, 0, 0)}},
+        {"lrwxrwxrwx    1 ftp      ftp             6 Feb 28  2020 latest -> pub/1.0", true,
+            Entry{Name: "latest", Size: 6, Time: date(2020, time.February, 28, 0, 0, 0)}},
+        {"drwxr-xr-x    2 ftp      ftp          4096 Jan 02  2006 .", false, Entry{}},
+        {"drwxr-xr-x    2 ftp      ftp          4096 Jan 02  2006 ..", false, Entry{}},
+        {"total 12", false, Entry{}},
+        {"", false, Entry{}},
+        // DOS / IIS
+        {"01-02-06  03:04PM       <DIR>          Sub Dir", true,
+            Entry{Name: "Sub Dir", IsDir: true, Time: date(2006, time.January, 2, 15, 4, 0)}},
+        {"12-31-2019  11:59AM                 1024 a.txt", true,
+            Entry{Name: "a.txt", Size: 1024, Time: date(2019, time.December, 31, 11, 59, 0)}},
+    }
+    for _, test := range tests {
+        entry, ok := parseListLine(test.line)
+        if ok != test.ok {
+            t.Errorf("parseListLine(%q) ok = %v", test.line, ok)
+            continue
+        }
+        if ok && (entry.Name != test.want.Name || entry.IsDir != test.want.IsDir || entry.Size != test.want.Size || entry.Time.Equal(test.want.Time) != true) {
+            t.Errorf("parseListLine(%q) = %+v, want %+v", test.line, entry, test.want)
+        }
+    }
+}
+
+func TestParseListLineRecent(t *testing.T) {
+    // No year: the last date with that month and day, not in the future
+    now := time.Now().UTC()
+    recent := now.AddDate(0, -1, 0)
+    line := "-rw-r--r-- 1 ftp ftp 3 " + recent.Format("Jan _2 15:04:05") + " recent"
+    entry, ok := parseListLine(line)
+    if ok != true || entry.Time.Equal(recent.Truncate(time.Second)) != true {
+        t.Errorf("parseListLine(%q) = %+v, want time %s", line, entry, recent)
+    }
+    future := now.AddDate(0, 0, 7)
+    line = "-rw-r--r-- 1 ftp ftp 3 " + future.Format("Jan _2 15:04") + " lastyear"
+    entry, ok = parseListLine(line)
+    if ok != true || entry.Time.Year() != future.Year() - 1 || entry.Time.After(now) {
+        t.Errorf("parseListLine(%q) = %+v, want last year", line, entry)
+    }
+}
+
+func TestParseMlsdLine(t *testing.T) {
+    tests := []struct {
+        line string
+        ok bool
+        want Entry
+    }{
+        {"type=file;size=1234;modify=20170101120000; name", true,
+            Entry{Name: "name", Size: 1234, Time: date(2017, time.January, 1, 12, 0, 0)}},
+        {"Type=dir;Modify=20170102030405.123;UNIX.mode=0755; sub dir", true,
+            Entry{Name: "sub dir", IsDir: true, Time: date(2017, time.January, 2, 3, 4, 5)}},
+        {"size=7;type=FILE;perm=r; a;b=c.txt", true,
+            Entry{Name: "a;b=c.txt", Size: 7}},
+        {"type=file;modify=bad; nodate", true, Entry{Name: "nodate"}},
+        {"type=cdir;modify=20170101120000; .", false, Entry{}},
+        {"type=pdir;modify=20170101120000; ..", false, Entry{}},
+        {"type=OS.unix=slink:/target;size=7; link", false, Entry{}},
+        {"type=file;size=1;", false, Entry{}},
+        {"type=file;size=1; ", false, Entry{}},
+    }
+    for _, test := range tests {
+        entry, ok := parseMlsdLine(test.line)
+        if ok != test.ok {
+            t.Errorf("parseMlsdLine(%q) ok = %v", test.line, ok)
+            continue
+        }
+        if ok && (entry.Name != test.want.Name || entry.IsDir != test.want.IsDir || entry.Size != test.want.Size || entry.Time.Equal(test.want.Time) != true) {
+            t.Errorf("parseMlsdLine(%q) = %+v, want %+v", test.line, entry, test.want)
+        }
+    }
+}
@@ -0,0 +1,42 @@
+package main
+
+// Admin HTTP interface, only started if "adminListen" is configured.
+// It has no authentication, bind it to a private address.
+
+import (
+    "fmt"
+    "metrics"
+    "net/http"
+    "parseindex"
+)
+
+func startAdmin(listenAddr string) {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/purge", adminPurge)
+    mux.HandleFunc("/metrics", adminMetrics)
+    fmt.Println("Admin interface listening on " + listenAddr)
+    go func() {
+        err := http.ListenAndServe(listenAddr, mux)
+        fmt.Println("Admin interface stopped:", err.Error())
+    }()
+}
+
+// POST /purge?path=/dir drops cached listings of /dir and below, everything without path
+func adminPurge(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "POST" {
+        http.Error(w, "Use POST", http.StatusMethodNotAllowed)
+        return
+    }
+    dirPrefix := r.URL.Query().Get("path")
+    if dirPrefix == "" {
+        dirPrefix = "/"
+    }
+    purged := parseindex.PurgeListings(dirPrefix)
+    fmt.Fprintf(w, "Purged %d listings\n", purged)
+}
+
+// GET /metrics returns counters in the Prometheus text format
+func adminMetrics(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    metrics.Write(w)
+}
@@ -9,10 +9,11 @@ import (
     "ftpIO"
     "parseindex"
     "path"
-    "net/http"
     "time"
     "cfg"
+    "contentcache"
     "sync"
+    "sort"
     "strconv"
 )
 
@@ -50,6 +51,7 @@ type Session struct {
     pasvListener *net.TCPListener   // Listener in PASV mode
     workingDir string
     timer *time.Timer
+    restOffset int64                // Set by REST, used by the next RETR
 }
 
 var state State
@@ -59,6 +61,28 @@ func main() {
     cfg.LoadConfig("ftproxy.conf")
     listenPort := cfg.GetListenPort()
     maxConnections := cfg.GetMaxConnections()
+    if cacheDir, cacheSize, cacheFills := cfg.GetContentCache(); cacheDir != "" {
+        err := contentcache.Init(cacheDir, cacheSize, cacheFills)
+        if err != nil {
+            fmt.Println("Cannot set up content cache:", err.Error())
+            os.Exit(1)
+        }
+    }
+    // Upstream HTTP settings of each mount, in prefix order for stable errors
+    mounts := cfg.GetMounts()
+    sort.Slice(mounts, func(i, j int) (bool) { return mounts[i].Prefix < mounts[j].Prefix })
+    for _, mount := range mounts {
+        for _, host := range mount.Hosts {
+            if err := ftpIO.SetHostClient(host, mount.HttpClient); err != nil {
+                fmt.Printf("Invalid mount %s: %s\n", mount.Prefix, err.Error())
+                os.Exit(1)
+            }
+        }
+    }
+    parseindex.StartHealthChecks()
+    if cfg.GetAdminListen() != "" {
+        startAdmin(cfg.GetAdminListen())
+    }
     // Listen for incoming connections.
     l, err := net.Listen(CONN_TYPE, CONN_HOST + ":" + listenPort)
     if err != nil {
@@ -115,9 +139,12 @@ func handleRequest(conn net.Conn) {
         "PASV": cmdPasv,
         "EPSV": cmdEpsv,
         "RETR": cmdRetr,
+        "REST": cmdRest,
         "PWD":  cmdPwd,
         "CWD":  cmdCwd,
         "LIST": cmdList,
+        "MLSD": cmdMlsd,
+        "MLST": cmdMlst,
         "MDTM": cmdMdtm,
         "SIZE": cmdSize,
         "SYST": cmdSyst,
@@ -374,17 +401,18 @@ func cmdRetr(session *Session, command Command) (bool) {
         fileName = session.workingDir + "/" + fileName
     }
     fileName = path.Clean(fileName)
-    vhost := cfg.GetVhost(fileName)
+    offset := session.restOffset
+    session.restOffset = 0
 
-    // Check if URL is accessible
-    var resp *http.Response
-    ret := ftpIO.OpenUrl(vhost, fileName, &resp)
-    if ret != true {
+    // Check if file is accessible
+    file, err := parseindex.OpenFile(fileName, offset)
+    if err != nil {
         // make sure ln is destroyed
         session.pasvListener.Close()
         session.pasvListener = nil
         session.dtpState = DTP_NONE
-        ftpIO.Write(session.commandConn, 550, "Failed to open file.")
+        code, text := parseindex.ErrorReply(err, 550, "Failed to open file.")
+        ftpIO.Write(session.commandConn, code, text)
         return false
     }
 
@@ -396,7 +424,7 @@ func cmdRetr(session *Session, command Command) (bool) {
         session.pasvListener.Close()
         session.pasvListener = nil
         session.dtpState = DTP_NONE
-        ftpIO.CloseUrl(resp)
+        file.Close()
         ftpIO.Write(session.commandConn, 500, "Failed to accept data connection.")
         return false
     }
@@ -409,15 +437,31 @@ func cmdRetr(session *Session, command Command) (bool) {
 
     ftpIO.Write(session.commandConn, 150, "Opening BINARY mode data connection for x.")
 
-    ret = ftpIO.SendUrl(session.dataConn, resp)
-    ftpIO.CloseUrl(resp)
+    err = ftpIO.Send(session.dataConn, file)
+    file.Close()
     ftpIO.Close(session.dataConn)
 
-    if ret != true {
-        ftpIO.Write(session.commandConn, 550, "Failed to open file.")
+    if err != nil {
+        code, text := parseindex.ErrorReply(err, 426, "Connection closed; transfer aborted.")
+        ftpIO.Write(session.commandConn, code, text)
+        return false
+    }
+    if stale, ok := file.(interface{ Warning() string }); ok {
+        ftpIO.Write(session.commandConn, 226, "Transfer complete (" + stale.Warning() + ").")
+    } else {
+        ftpIO.Write(session.commandConn, 226, "Transfer complete.")
+    }
+    return true
+}
+
+func cmdRest(session *Session, command Command) (bool) {
+    offset, err := strconv.ParseInt(command.Args, 10, 64)
+    if err != nil || offset < 0 {
+        ftpIO.Write(session.commandConn, 501, "Bad REST offset.")
         return false
     }
-    ftpIO.Write(session.commandConn, 226, "Transfer complete.")
+    session.restOffset = offset
+    ftpIO.Write(session.commandConn, 350, fmt.Sprintf("Restart position accepted (%d).", offset))
     return true
 }
 
@@ -445,6 +489,15 @@ func cmdCwd(session *Session, command Command) (bool) {
 }
 
 func cmdList(session *Session, command Command) (bool) {
+    return sendListing(session, command, parseindex.ListDir)
+}
+
+// Machine readable listing (RFC 3659), redirects are OS.unix=slink entries
+func cmdMlsd(session *Session, command Command) (bool) {
+    return sendListing(session, command, parseindex.ListDirMlsd)
+}
+
+func sendListing(session *Session, command Command, listDir func(string) (string, string, error)) (bool) {
     if session.dtpState == DTP_NONE {
         ftpIO.Write(session.commandConn, 425, "Use PORT or PASV first.")
         return false
@@ -483,21 +536,46 @@ func cmdList(session *Session, command Command) (bool) {
 
     ftpIO.Write(session.commandConn, 150, "Opening BINARY mode data connection for x.")
 
-    listing, ret := parseindex.DirList(dirName)
-    if ret != true {
-        ftpIO.Write(session.commandConn, 526, "Failed to send directory, please retry.")
+    listing, warning, err := listDir(dirName)
+    if err != nil {
+        ftpIO.Close(session.dataConn)
+        code, text := parseindex.ErrorReply(err, 451, "Failed to list directory.")
+        ftpIO.Write(session.commandConn, code, text)
         return false
     }
     ftpIO.WriteRaw(session.dataConn, listing)
     ftpIO.Close(session.dataConn)
 
-    ftpIO.Write(session.commandConn, 226, "Directory send OK.")
+    if warning != "" {
+        ftpIO.Write(session.commandConn, 226, "Directory send OK (" + warning + ").")
+    } else {
+        ftpIO.Write(session.commandConn, 226, "Directory send OK.")
+    }
 
     return true
 }
 
+// Facts of a single file or directory, on the control connection
+func cmdMlst(session *Session, command Command) (bool) {
+    fileName := command.Args
+
+    if !strings.HasPrefix(fileName, "/") {
+        fileName = session.workingDir + "/" + fileName
+    }
+    fileName = path.Clean(fileName)
+
+    entry, err := parseindex.MlstEntry(fileName)
+    if err != nil {
+        code, text := parseindex.ErrorReply(err, 451, "Failed to get file facts.")
+        ftpIO.Write(session.commandConn, code, text)
+        return false
+    }
+    ftpIO.WriteRaw(session.commandConn, "250-Listing " + fileName + "\r\n " + entry + "\r\n250 End\r\n")
+    return true
+}
+
 func cmdFeat(session *Session, command Command) (bool) {
-    featReply := "211-Features:\r\n MDTM\r\n SIZE\r\n EPSV\r\n211 End\r\n"
+    featReply := "211-Features:\r\n MDTM\r\n SIZE\r\n EPSV\r\n REST STREAM\r\n MLST type*;size*;modify*;\r\n211 End\r\n"
 
     ftpIO.WriteRaw(session.commandConn, featReply)
     return true
@@ -535,6 +613,10 @@ func cmdSize(session *Session, command Command) (bool) {
         ftpIO.Write(session.commandConn, 550, "Could not get file size.")
         return false
     }
+    if fileSize < 0 {
+        ftpIO.Write(session.commandConn, 550, "File size unknown until the file has been retrieved.")
+        return false
+    }
 
     ftpIO.Write(session.commandConn, 213, strconv.FormatInt(fileSize, 10))
 
@@ -0,0 +1,195 @@
+package gitrepo
+
+// Read-only access to a local (bare) git repository through the git command
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "os/exec"
+    "strconv"
+    "strings"
+    "time"
+)
+
+var ErrNotFound = errors.New("not found in repository")
+
+// A branch or a tag
+type Ref struct {
+    Name string         // Short name, e.g. "master" or "v1.0"
+    RefName string      // Full name given to git, e.g. "refs/heads/master"
+    Time time.Time      // Commit time
+}
+
+// A tree entry
+type Entry struct {
+    Name string
+    IsDir bool
+    Size int64
+}
+
+type Repo struct {
+    GitDir string
+}
+
+func (r *Repo) git(args ...string) ([]byte, error) {
+    cmd := exec.Command("git", append([]string{"--git-dir=" + r.GitDir}, args...)...)
+    var stderr bytes.Buffer
+    cmd.Stderr = &stderr
+    out, err := cmd.Output()
+    if err != nil {
+        return nil, fmt.Errorf("git %s: %s: %s", args[0], err.Error(), strings.TrimSpace(stderr.String()))
+    }
+    return out, nil
+}
+
+// Read branches and tags, branches win over tags of the same name.
+// Refs are read again on every call so that repository updates are seen.
+func (r *Repo) Refs() ([]Ref, error) {
+    out, err := r.git("for-each-ref", "--format=%(refname)%09%(committerdate:unix)%09%(*committerdate:unix)", "refs/heads", "refs/tags")
+    if err != nil {
+        return nil, err
+    }
+    var refs []Ref
+    seen := make(map[string]bool)
+    for _, line := range strings.Split(string(out), "\n") {
+        fields := strings.Split(line, "\t")
+        if len(fields) != 3 {
+            continue
+        }
+        var name string
+        if strings.HasPrefix(fields[0], "refs/heads/") {
+            name = strings.TrimPrefix(fields[0], "refs/heads/")
+        } else {
+            name = strings.TrimPrefix(fields[0], "refs/tags/")
+        }
+        if seen[name] {
+            continue
+        }
+        // Annotated tags carry the commit time on the dereferenced object
+        unixTime := fields[1]
+        if unixTime == "" {
+            unixTime = fields[2]
+        }
+        seconds, err := strconv.ParseInt(unixTime, 10, 64)
+        if err != nil {
+            // Tag of something that is not a commit
+            continue
+        }
+        seen[name] = true
+        refs = append(refs, Ref{Name: name, RefName: fields[0], Time: time.Unix(seconds, 0)})
+    }
+    return refs, nil
+}
+
+// Return the revision of a full ref name, usable in "<rev>:<path>".
+// Short names are ambiguous, git prefers tags over branches.
+func (r *Repo) revision(refName string) (string) {
+    return refName + "^{commit}"
+}
+
+// List the tree at treePath ("" for the root) in the full ref refName
+func (r *Repo) List(refName string, treePath string) ([]Entry, error) {
+    out, err := r.git("ls-tree", "-l", "-z", r.revision(refName) + ":" + treePath)
+    if err != nil {
+        return nil, ErrNotFound
+    }
+    var entries []Entry
+    for _, record := range strings.Split(string(out), "\x00") {
+        // "<mode> <type> <object> <size>\t<name>"
+        pieces := strings.SplitN(record, "\t", 2)
+        if len(pieces) != 2 {
+            continue
+        }
+        fields := strings.Fields(pieces[0])
+        if len(fields) != 4 {
+            continue
+        }
+        entry := Entry{Name: pieces[1]}
+        switch fields[1] {
+        case "tree":
+            entry.IsDir = true
+        case "blob":
+            entry.Size, _ = strconv.ParseInt(fields[3], 10, 64)
+        default:
+            // Submodules
+            continue
+        }
+        entries = append(entries, entry)
+    }
+    return entries, nil
+}
+
+// Blob content, read from the git process output.
+// A failure of the process is returned by Read instead of EOF.
+type BlobReader struct {
+    cmd *exec.Cmd
+    stdout io.ReadCloser
+    stderr bytes.Buffer
+    eof bool
+    waited bool
+    err error                   // Of the process
+}
+
+func (b *BlobReader) wait() (error) {
+    if b.waited != true {
+        b.waited = true
+        if err := b.cmd.Wait(); err != nil {
+            b.err = fmt.Errorf("git cat-file: %s: %s", err.Error(), strings.TrimSpace(b.stderr.String()))
+        }
+    }
+    return b.err
+}
+
+func (b *BlobReader) Read(p []byte) (int, error) {
+    n, err := b.stdout.Read(p)
+    if err == io.EOF {
+        b.eof = true
+        if waitErr := b.wait(); waitErr != nil {
+            return n, waitErr
+        }
+    }
+    return n, err
+}
+
+func (b *BlobReader) Close() (error) {
+    if b.eof != true && b.waited != true {
+        // Closed before the end (ABOR, client gone), git would die of
+        // a broken pipe: its exit status means nothing
+        b.cmd.Process.Kill()
+        b.stdout.Close()
+        b.wait()
+        return nil
+    }
+    b.stdout.Close()
+    return b.wait()
+}
+
+// Open the blob at blobPath in the full ref refName, starting at offset
+func (r *Repo) Open(refName string, blobPath string, offset int64) (*BlobReader, error) {
+    object := r.revision(refName) + ":" + blobPath
+    // Check existence and type first, cat-file errors would only show on Close()
+    out, err := r.git("cat-file", "-t", object)
+    if err != nil || strings.TrimSpace(string(out)) != "blob" {
+        return nil, ErrNotFound
+    }
+    cmd := exec.Command("git", "--git-dir=" + r.GitDir, "cat-file", "blob", object)
+    blob := &BlobReader{cmd: cmd}
+    cmd.Stderr = &blob.stderr
+    stdout, err := cmd.StdoutPipe()
+    if err != nil {
+        return nil, err
+    }
+    if err = cmd.Start(); err != nil {
+        return nil, err
+    }
+    blob.stdout = stdout
+    if offset > 0 {
+        if _, err = io.CopyN(io.Discard, blob, offset); err != nil {
+            blob.Close()
+            return nil, err
+        }
+    }
+    return blob, nil
+}
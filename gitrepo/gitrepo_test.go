@@ -0,0 +1,105 @@
+package gitrepo
+
+import (
+    "bytes"
+    "io"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "testing"
+)
+
+// A repository with a 1 MB blob, more than a pipe holds, on master and
+// a "same" branch and tag pointing at different commits
+func newTestRepo(t *testing.T) (*Repo, []byte) {
+    if _, err := exec.LookPath("git"); err != nil {
+        t.Skip("no git")
+    }
+    dir := t.TempDir()
+    run := func(args ...string) {
+        cmd := exec.Command("git", args...)
+        cmd.Dir = dir
+        cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@test",
+            "GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@test")
+        if out, err := cmd.CombinedOutput(); err != nil {
+            t.Fatalf("git %v: %s: %s", args, err, out)
+        }
+    }
+    data := bytes.Repeat([]byte("0123456789abcdef"), 64 * 1024)
+    run("init", "-q", "-b", "master")
+    os.WriteFile(filepath.Join(dir, "big.bin"), data, 0644)
+    os.WriteFile(filepath.Join(dir, "which"), []byte("tag\n"), 0644)
+    run("add", ".")
+    run("commit", "-q", "-m", "first")
+    run("tag", "same")
+    os.WriteFile(filepath.Join(dir, "which"), []byte("branch\n"), 0644)
+    run("commit", "-q", "-am", "second")
+    run("branch", "same")
+    return &Repo{GitDir: filepath.Join(dir, ".git")}, data
+}
+
+func readBlob(t *testing.T, repo *Repo, refName string, blobPath string, offset int64) ([]byte) {
+    t.Helper()
+    blob, err := repo.Open(refName, blobPath, offset)
+    if err != nil {
+        t.Fatal(err)
+    }
+    data, err := io.ReadAll(blob)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err = blob.Close(); err != nil {
+        t.Fatal(err)
+    }
+    return data
+}
+
+func TestOpen(t *testing.T) {
+    repo, data := newTestRepo(t)
+    if got := readBlob(t, repo, "refs/heads/master", "big.bin", 0); bytes.Equal(got, data) != true {
+        t.Errorf("read %d bytes of %d", len(got), len(data))
+    }
+    if got := readBlob(t, repo, "refs/heads/master", "big.bin", 1000); bytes.Equal(got, data[1000:]) != true {
+        t.Errorf("read %d bytes from offset 1000", len(got))
+    }
+    // Full ref names tell the branch from the tag
+    if got := readBlob(t, repo, "refs/heads/same", "which", 0); string(got) != "branch\n" {
+        t.Errorf("branch has %q", got)
+    }
+    if got := readBlob(t, repo, "refs/tags/same", "which", 0); string(got) != "tag\n" {
+        t.Errorf("tag has %q", got)
+    }
+    if _, err := repo.Open("refs/heads/master", "missing", 0); err != ErrNotFound {
+        t.Errorf("missing blob gives %v", err)
+    }
+}
+
+func TestCloseEarly(t *testing.T) {
+    repo, _ := newTestRepo(t)
+    blob, err := repo.Open("refs/heads/master", "big.bin", 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err = io.ReadFull(blob, make([]byte, 100)); err != nil {
+        t.Fatal(err)
+    }
+    // An aborted transfer is not a git failure
+    if err = blob.Close(); err != nil {
+        t.Errorf("early close gives %v", err)
+    }
+}
+
+func TestRefs(t *testing.T) {
+    repo, _ := newTestRepo(t)
+    refs, err := repo.Refs()
+    if err != nil {
+        t.Fatal(err)
+    }
+    names := make(map[string]string)
+    for _, ref := range refs {
+        names[ref.Name] = ref.RefName
+    }
+    if len(refs) != 2 || names["master"] != "refs/heads/master" || names["same"] != "refs/heads/same" {
+        t.Errorf("refs are %+v", refs)
+    }
+}
@@ -0,0 +1,325 @@
+package iso9660
+
+// Read-only ISO 9660 parser working on an io.ReaderAt, with
+// Rock Ridge (preferred) and Joliet long names
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "io"
+    "path"
+    "strings"
+    "time"
+    "unicode/utf16"
+)
+
+const SECTOR_SIZE = 2048
+
+var ErrNotFound = errors.New("not found in image")
+var ErrNotIso = errors.New("not an ISO 9660 image")
+
+// A contiguous part of a file, in bytes from the start of the image
+type Extent struct {
+    Offset int64
+    Length int64
+}
+
+type Entry struct {
+    Name string
+    IsDir bool
+    Size int64
+    Time time.Time
+    Extents []Extent
+}
+
+type Image struct {
+    r io.ReaderAt
+    joliet bool
+    rockRidge bool
+    suspSkip int      // Bytes to skip in each system use area (SP entry)
+    root Entry
+}
+
+func readAt(r io.ReaderAt, offset int64, length int64) ([]byte, error) {
+    buf := make([]byte, length)
+    if _, err := r.ReadAt(buf, offset); err != nil && err != io.EOF {
+        return nil, err
+    }
+    return buf, nil
+}
+
+// Recording date of directory records, 7 bytes
+func parseTime(b []byte) (time.Time) {
+    if len(b) < 7 || b[1] == 0 {
+        return time.Time{}
+    }
+    zone := time.FixedZone("", int(int8(b[6])) * 15 * 60)
+    return time.Date(1900 + int(b[0]), time.Month(b[1]), int(b[2]), int(b[3]), int(b[4]), int(b[5]), 0, zone)
+}
+
+// A raw directory record, without name decoding
+type record struct {
+    entry Entry
+    rawName []byte
+    systemUse []byte
+    multiExtent bool
+}
+
+func parseRecord(b []byte) (record, bool) {
+    var rec record
+    if len(b) < 34 {
+        return rec, false
+    }
+    nameLen := int(b[32])
+    if 33 + nameLen > len(b) {
+        return rec, false
+    }
+    flags := b[25]
+    rec.entry.IsDir = flags & 0x02 != 0
+    rec.multiExtent = flags & 0x80 != 0
+    extentOffset := int64(binary.LittleEndian.Uint32(b[2:6])) * SECTOR_SIZE
+    rec.entry.Size = int64(binary.LittleEndian.Uint32(b[10:14]))
+    rec.entry.Extents = []Extent{{Offset: extentOffset, Length: rec.entry.Size}}
+    rec.entry.Time = parseTime(b[18:25])
+    rec.rawName = b[33:33 + nameLen]
+    systemUseStart := 33 + nameLen
+    if nameLen % 2 == 0 {
+        // Padding byte
+        systemUseStart++
+    }
+    if systemUseStart < len(b) {
+        rec.systemUse = b[systemUseStart:]
+    }
+    return rec, true
+}
+
+// Return the SUSP entries of a system use area, following CE continuations
+func (img *Image) suspEntries(systemUse []byte) (map[string][][]byte) {
+    entries := make(map[string][][]byte)
+    area := systemUse
+    if len(area) >= img.suspSkip {
+        area = area[img.suspSkip:]
+    }
+    // Bound the number of continuation areas, images can be malicious
+    for areas := 0; areas < 16 && len(area) > 0; areas++ {
+        var next []byte
+        for len(area) >= 4 {
+            sig := string(area[0:2])
+            entryLen := int(area[2])
+            if entryLen < 4 || entryLen > len(area) {
+                break
+            }
+            entries[sig] = append(entries[sig], area[4:entryLen])
+            if sig == "CE" && entryLen >= 28 {
+                block := int64(binary.LittleEndian.Uint32(area[4:8]))
+                offset := int64(binary.LittleEndian.Uint32(area[12:16]))
+                length := int64(binary.LittleEndian.Uint32(area[20:24]))
+                if length <= SECTOR_SIZE {
+                    next, _ = readAt(img.r, block * SECTOR_SIZE + offset, length)
+                }
+            }
+            if sig == "ST" {
+                break
+            }
+            area = area[entryLen:]
+        }
+        area = next
+    }
+    return entries
+}
+
+// Decode the name of a record, "" for "." and ".."
+func (img *Image) recordName(rec record) (string, map[string][][]byte) {
+    if len(rec.rawName) == 1 && (rec.rawName[0] == 0 || rec.rawName[0] == 1) {
+        return "", nil
+    }
+    var susp map[string][][]byte
+    if img.rockRidge {
+        susp = img.suspEntries(rec.systemUse)
+        var name []byte
+        for _, nm := range susp["NM"] {
+            // Flags byte, then name content
+            if len(nm) >= 1 && nm[0] & 0x06 == 0 {
+                name = append(name, nm[1:]...)
+            }
+        }
+        if len(name) > 0 {
+            return string(name), susp
+        }
+    }
+    var name string
+    if img.joliet {
+        u16 := make([]uint16, len(rec.rawName) / 2)
+        for i := range u16 {
+            u16[i] = binary.BigEndian.Uint16(rec.rawName[2 * i:])
+        }
+        name = string(utf16.Decode(u16))
+    } else {
+        name = string(rec.rawName)
+    }
+    // "NAME.EXT;1" -> "NAME.EXT", "NAME.;1" -> "NAME"
+    if i := strings.LastIndex(name, ";"); i >= 0 && rec.entry.IsDir != true {
+        name = name[:i]
+    }
+    if rec.entry.IsDir != true {
+        name = strings.TrimSuffix(name, ".")
+    }
+    return name, susp
+}
+
+func Open(r io.ReaderAt) (*Image, error) {
+    img := &Image{r: r}
+    var primaryRoot []byte
+    var jolietRoot []byte
+    for sector := int64(16); sector < 16 + 64; sector++ {
+        descriptor, err := readAt(r, sector * SECTOR_SIZE, SECTOR_SIZE)
+        if err != nil {
+            return nil, err
+        }
+        if string(descriptor[1:6]) != "CD001" {
+            return nil, ErrNotIso
+        }
+        descType := descriptor[0]
+        if descType == 255 {
+            break
+        }
+        if descType == 1 && primaryRoot == nil {
+            primaryRoot = descriptor[156:156 + 34]
+        }
+        // Joliet is a supplementary descriptor with UCS-2 escape sequences
+        escape := descriptor[88:91]
+        if descType == 2 && (bytes.Equal(escape, []byte("%/@")) || bytes.Equal(escape, []byte("%/C")) || bytes.Equal(escape, []byte("%/E"))) {
+            jolietRoot = descriptor[156:156 + 34]
+        }
+    }
+    if primaryRoot == nil {
+        return nil, ErrNotIso
+    }
+    rootRec, ret := parseRecord(primaryRoot)
+    if ret != true {
+        return nil, ErrNotIso
+    }
+    img.root = rootRec.entry
+
+    // Rock Ridge is announced by an SP entry in the first record ("." ) of the root
+    dot, err := readAt(r, rootRec.entry.Extents[0].Offset, SECTOR_SIZE)
+    if err == nil && dot[0] >= 34 {
+        if dotRec, ret := parseRecord(dot[:dot[0]]); ret == true {
+            su := dotRec.systemUse
+            if len(su) >= 7 && string(su[0:2]) == "SP" && su[4] == 0xBE && su[5] == 0xEF {
+                img.rockRidge = true
+                img.suspSkip = int(su[6])
+            }
+        }
+    }
+    if img.rockRidge != true && jolietRoot != nil {
+        if jolietRec, ret := parseRecord(jolietRoot); ret == true {
+            img.joliet = true
+            img.root = jolietRec.entry
+        }
+    }
+    return img, nil
+}
+
+func (img *Image) Root() (Entry) {
+    return img.root
+}
+
+// List a directory entry
+func (img *Image) ReadDir(dir Entry) ([]Entry, error) {
+    if dir.IsDir != true || len(dir.Extents) == 0 {
+        return nil, ErrNotFound
+    }
+    // Directories are at most a few sectors, a bound avoids huge reads on corrupt images
+    if dir.Size > 16 * 1024 * 1024 {
+        return nil, ErrNotIso
+    }
+    data, err := readAt(img.r, dir.Extents[0].Offset, dir.Size)
+    if err != nil {
+        return nil, err
+    }
+    var entries []Entry
+    var pending *Entry
+    for pos := 0; pos < len(data); {
+        recLen := int(data[pos])
+        if recLen == 0 {
+            // Records do not cross sectors, skip padding
+            pos = (pos / SECTOR_SIZE + 1) * SECTOR_SIZE
+            continue
+        }
+        if pos + recLen > len(data) {
+            break
+        }
+        rec, ret := parseRecord(data[pos:pos + recLen])
+        pos += recLen
+        if ret != true {
+            continue
+        }
+        name, susp := img.recordName(rec)
+        if name == "" {
+            continue
+        }
+        // Rock Ridge relocated directories appear through their CL entry instead
+        if _, relocated := susp["RE"]; relocated {
+            continue
+        }
+        if cl, exists := susp["CL"]; exists && len(cl[0]) >= 4 {
+            rec.entry.IsDir = true
+            block := int64(binary.LittleEndian.Uint32(cl[0][0:4]))
+            childDot, err := readAt(img.r, block * SECTOR_SIZE, SECTOR_SIZE)
+            if err == nil && childDot[0] >= 34 {
+                if childRec, ret := parseRecord(childDot[:childDot[0]]); ret == true {
+                    rec.entry.Extents = childRec.entry.Extents
+                    rec.entry.Size = childRec.entry.Size
+                }
+            }
+        }
+        rec.entry.Name = name
+        // Files over 4GB use several records with the multi-extent flag
+        if pending != nil {
+            pending.Extents = append(pending.Extents, rec.entry.Extents...)
+            pending.Size += rec.entry.Size
+            if rec.multiExtent != true {
+                entries = append(entries, *pending)
+                pending = nil
+            }
+            continue
+        }
+        if rec.multiExtent {
+            entry := rec.entry
+            pending = &entry
+            continue
+        }
+        entries = append(entries, rec.entry)
+    }
+    return entries, nil
+}
+
+// Find the entry at entryPath, relative to the root
+func (img *Image) Lookup(entryPath string) (Entry, error) {
+    entry := img.root
+    entryPath = strings.Trim(path.Clean("/" + entryPath), "/")
+    if entryPath == "" {
+        return entry, nil
+    }
+    for _, piece := range strings.Split(entryPath, "/") {
+        entries, err := img.ReadDir(entry)
+        if err != nil {
+            return entry, err
+        }
+        found := false
+        for _, child := range entries {
+            // Plain ISO 9660 names are upper case, accept any case
+            if child.Name == piece || (img.rockRidge != true && img.joliet != true && strings.EqualFold(child.Name, piece)) {
+                entry = child
+                found = true
+                break
+            }
+        }
+        if found != true {
+            return entry, ErrNotFound
+        }
+    }
+    return entry, nil
+}
@@ -0,0 +1,104 @@
+package iso9660
+
+import (
+    "bytes"
+    "errors"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// The images hold "<alpha>" (6 bytes) and "<dir>/<big>" (10240 bytes
+// spanning sectors), with their Rock Ridge or Joliet names
+var testImages = []struct {
+    file string
+    alpha string
+    dir string
+    big string
+}{
+    {"rr.iso", "alpha.txt", "Sub Dir", "big-file.bin"},
+    {"j.iso", "alpha-joliet.txt", "Joliet Dir", "big joliet.bin"},
+}
+
+var testTime = time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+func openTestImage(t *testing.T, name string) (*Image, []byte) {
+    data, err := os.ReadFile(filepath.Join("testdata", name))
+    if err != nil {
+        t.Fatal(err)
+    }
+    img, err := Open(bytes.NewReader(data))
+    if err != nil {
+        t.Fatalf("%s: %v", name, err)
+    }
+    return img, data
+}
+
+// Concatenate the extents of entry
+func extentData(data []byte, entry Entry) ([]byte) {
+    var content []byte
+    for _, extent := range entry.Extents {
+        content = append(content, data[extent.Offset:extent.Offset + extent.Length]...)
+    }
+    return content
+}
+
+func TestReadDir(t *testing.T) {
+    for _, test := range testImages {
+        img, _ := openTestImage(t, test.file)
+        entries, err := img.ReadDir(img.Root())
+        if err != nil {
+            t.Fatalf("%s: %v", test.file, err)
+        }
+        if len(entries) != 2 {
+            t.Fatalf("%s: root has %d entries, want 2: %v", test.file, len(entries), entries)
+        }
+        if entries[0].Name != test.alpha || entries[0].IsDir || entries[0].Size != 6 {
+            t.Errorf("%s: first entry is %+v", test.file, entries[0])
+        }
+        if entries[1].Name != test.dir || entries[1].IsDir != true {
+            t.Errorf("%s: second entry is %+v", test.file, entries[1])
+        }
+        if entries[0].Time.Equal(testTime) != true {
+            t.Errorf("%s: time is %s, want %s", test.file, entries[0].Time, testTime)
+        }
+    }
+}
+
+func TestLookup(t *testing.T) {
+    big := bytes.Repeat(make([]byte, 256), 40)
+    for i := range big {
+        big[i] = byte(i % 256)
+    }
+    for _, test := range testImages {
+        img, data := openTestImage(t, test.file)
+        alpha, err := img.Lookup(test.alpha)
+        if err != nil {
+            t.Fatalf("%s: %v", test.file, err)
+        }
+        if got := extentData(data, alpha); string(got) != "alpha\n" {
+            t.Errorf("%s: %s is %q", test.file, test.alpha, got)
+        }
+        entry, err := img.Lookup("/" + test.dir + "/" + test.big)
+        if err != nil {
+            t.Fatalf("%s: %v", test.file, err)
+        }
+        if entry.Size != int64(len(big)) || bytes.Equal(extentData(data, entry), big) != true {
+            t.Errorf("%s: %s has wrong content, %d bytes", test.file, test.big, entry.Size)
+        }
+        if _, err = img.Lookup(test.dir + "/missing"); errors.Is(err, ErrNotFound) != true {
+            t.Errorf("%s: missing entry gives %v", test.file, err)
+        }
+        // Names as recorded, only plain ISO 9660 names are case insensitive
+        if _, err = img.Lookup("A.TXT"); errors.Is(err, ErrNotFound) != true {
+            t.Errorf("%s: plain name found despite %s", test.file, test.alpha)
+        }
+    }
+}
+
+func TestOpenNotIso(t *testing.T) {
+    if _, err := Open(bytes.NewReader(make([]byte, 20 * SECTOR_SIZE))); err == nil {
+        t.Errorf("empty image opened")
+    }
+}
@@ -0,0 +1,53 @@
+package metrics
+
+// Process-wide counters and gauges, written in the Prometheus text format.
+// Names may carry labels, e.g. `ftproxy_breaker_open{endpoint="10.0.0.1"}`.
+
+import (
+    "fmt"
+    "io"
+    "sort"
+    "sync"
+)
+
+type Metrics struct {
+    sync.Mutex
+    values map[string]int64
+}
+
+var metrics = Metrics{values: make(map[string]int64)}
+
+func Add(name string, delta int64) {
+    metrics.Lock()
+    metrics.values[name] += delta
+    metrics.Unlock()
+}
+
+func Inc(name string) {
+    Add(name, 1)
+}
+
+func Set(name string, value int64) {
+    metrics.Lock()
+    metrics.values[name] = value
+    metrics.Unlock()
+}
+
+func Get(name string) (int64) {
+    metrics.Lock()
+    defer metrics.Unlock()
+    return metrics.values[name]
+}
+
+func Write(w io.Writer) {
+    metrics.Lock()
+    names := make([]string, 0, len(metrics.values))
+    for name := range metrics.values {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        fmt.Fprintf(w, "%s %d\n", name, metrics.values[name])
+    }
+    metrics.Unlock()
+}
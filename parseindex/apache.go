@@ -1,22 +1,67 @@
 package parseindex
 
-import "fmt"
+// Apache mod_autoindex listings: FancyIndexing as a table (HTMLTable) or
+// as preformatted text, with or without icons, dates and sizes, and plain
+// <ul> lists. An entry is a link, its date and size are found by their
+// format in the text following it, up to the end of the row or line.
+
 import "golang.org/x/net/html"
 import "io"
 import "regexp"
-import "strconv"
 import "strings"
+import "time"
+
+var apacheDateRe = regexp.MustCompile("[0-9]{4}-[0-9]{2}-[0-9]{2} [0-9]{2}:[0-9]{2}(:[0-9]{2})?|[0-9]{2}-[A-Za-z]{3}-[0-9]{4} [0-9]{2}:[0-9]{2}(:[0-9]{2})?")
+
+var apacheDateFormats = []string{
+    "2006-01-02 15:04",
+    "2006-01-02 15:04:05",
+    "02-Jan-2006 15:04",
+    "02-Jan-2006 15:04:05",
+}
 
+// Set the date and size of object from the text following its link
+func apacheFields(object *FsObject, fields string, sizeColumn bool) {
+    if match := apacheDateRe.FindStringIndex(fields); match != nil {
+        for _, format := range apacheDateFormats {
+            if tim, err := time.Parse(format, fields[match[0]:match[1]]); err == nil {
+                object.time = tim
+                break
+            }
+        }
+        fields = fields[match[1]:]
+    }
+    tokens := strings.Fields(fields)
+    if sizeColumn != true || len(tokens) == 0 || object.otype == FS_DIR {
+        return
+    }
+    // Not "-" or a description
+    if size, ok := parseHumanSize(tokens[0]); ok {
+        object.size = size
+    }
+}
 
 func ParseApacheHtmlList(r io.Reader) ([]FsObject) {
     z := html.NewTokenizer(r)
 
-    var inFirstTable bool
-    var inTr bool
-    var inTd bool
-    var tdNumber int // td number within current tr
-    curObj := new(FsObject)
     var objects []FsObject
+    var curObj *FsObject        // Entry whose fields are being read
+    var fields string           // Text following its link
+    var header string           // Column names, before the first entry
+    var iconAlt string          // Of the icon before the next link
+    var inLink bool
+    var inTh bool
+    var inPre bool
+    sizeColumn := true
+
+    finish := func() {
+        if curObj != nil {
+            apacheFields(curObj, fields, sizeColumn)
+            objects = append(objects, *curObj)
+        }
+        curObj = nil
+        fields = ""
+    }
 
     for {
     tt := z.Next()
@@ -24,85 +69,68 @@ func ParseApacheHtmlList(r io.Reader) ([]FsObject) {
     switch tt {
     case html.ErrorToken:
         // End of the document, we're done
+        finish()
         return objects
-    case html.StartTagToken:
+    case html.StartTagToken, html.SelfClosingTagToken:
         t := z.Token()
-
-        if t.Data == "table" {
-            inFirstTable = true
-        }
-        if inFirstTable == true && t.Data == "tr" {
-            inTr = true
-            tdNumber = 0
-            curObj.otype = FS_NONE
-            curObj.name = ""
-            curObj.size = 0
-        }
-        if inTr == true && t.Data == "td" {
-            inTd = true
-            tdNumber++
-            if tdNumber == 4 {  // Size
-                z.Next()
-                sizeText := z.Token()
-                suffixMult := map[string]float64{
-                    "K": 1024,
-                    "M": 1024*1024,
-                    "G": 1024*1024*1024,
-                    "T": 1024*1024*1024*1024,
-                }
-                fmt.Printf(" size: %s ", sizeText)
-                re := regexp.MustCompile("([0-9]+(?:\\.?[0-9]+)?)([K|M|])?")
-                match := re.FindStringSubmatch(sizeText.String())
-                if match == nil {
-                    fmt.Println("No size regex match")
-                } else {
-                    preSize, err := strconv.ParseFloat(match[1], 64)
-                    if err == nil {
-                        if match[2] != "" {
-                            curObj.size = int64(preSize * suffixMult[match[2]])
-                        } else {
-                            curObj.size = int64(preSize)
-                        }
-                        fmt.Printf("match: %d\n", curObj.size)
-                    } else {
-                        // Better lie about the size than return empty which will confuse the client even more
-                        fmt.Println(err)
-                        curObj.size = 3
-                    }
-                } 
+        switch t.Data {
+        case "a":
+            inLink = true
+            object, ok := hrefEntry(getTokenAttr(&t, "href"))
+            if ok != true {
+                break
             }
-        }
-        if inTd == true && t.Data == "a" {
-            inTd = true
-            fmt.Printf("link: %s ", getTokenAttr(&t, "href"))
-            curObj.name = getTokenAttr(&t, "href")
-        }
-        if inTd == true && t.Data == "img" {
-            imgText := getTokenAttr(&t, "alt")
-            //fmt.Printf("alt: %s ", imgText)
-            if strings.Contains(imgText, "DIR") {
-                curObj.otype = FS_DIR
-            } else if strings.Contains(imgText, "[ ") {
-                curObj.otype = FS_FILE
+            finish()
+            if len(objects) == 0 && strings.Contains(header, "Name") {
+                // SuppressSize drops the column, but keeps the description
+                sizeColumn = strings.Contains(header, "Size")
             }
+            if strings.Contains(iconAlt, "[DIR]") {
+                object.otype = FS_DIR
+                object.size = 4096 /* XXX fake size */
+            }
+            iconAlt = ""
+            curObj = &object
+        case "img":
+            iconAlt = getTokenAttr(&t, "alt")
+        case "td":
+            fields += " "
+        case "th":
+            inTh = true
+        case "pre":
+            inPre = true
         }
-
     case html.EndTagToken:
         t := z.Token()
-        if t.Data == "table" {
-            // return objects 
-            inFirstTable = false // ugly, scan all tables
+        switch t.Data {
+        case "a":
+            inLink = false
+        case "th":
+            inTh = false
+        case "pre":
+            inPre = false
+            finish()
+        case "tr", "li", "table", "ul":
+            finish()
         }
-        if t.Data == "tr" {
-            inTr = false
-            if curObj.name != "" && curObj.otype != FS_NONE {
-                // fmt.Println(curObj)
-                objects = append(objects, *curObj)
+    case html.TextToken:
+        text := string(z.Text())
+        if curObj == nil {
+            if len(objects) == 0 && (inLink || inTh || inPre) {
+                header += text
             }
+            break
+        }
+        if inLink {
+            break
         }
-        if t.Data == "td" {
-            inTd = false
+        // A line ends an entry of a preformatted listing
+        if line := strings.Index(text, "\n"); inPre && line >= 0 {
+            fields += text[:line]
+            finish()
+            break
         }
+        fields += text
+    }
     }
-}
 }
@@ -0,0 +1,42 @@
+package parseindex
+
+import "strings"
+import "testing"
+
+func TestParseApacheHtmlList(t *testing.T) {
+    epoch := testTime("1970-01-01T00:00:00Z")
+    tests := []struct {
+        file string
+        want []FsObject
+    }{
+        {"apache-table.html", []FsObject{
+            {otype: FS_DIR, name: "sub", size: 4096, time: testTime("2024-01-02T11:00:00Z")},
+            {otype: FS_FILE, name: "big.iso", size: 1288490188, time: testTime("2024-01-01T10:00:00Z")},
+            {otype: FS_FILE, name: "my notes.txt", size: 17, time: testTime("2023-05-06T07:08:00Z")},
+            {otype: FS_FILE, name: "a:b", size: 2748779069440, time: testTime("2023-05-06T07:08:00Z")},
+        }},
+        // No size column, the description is not a size
+        {"apache-table-minimal.html", []FsObject{
+            {otype: FS_DIR, name: "sub", size: 4096, time: epoch},
+            {otype: FS_FILE, name: "file.txt", size: -1, time: epoch},
+        }},
+        // Names truncated in the text are taken from the links
+        {"apache-pre.html", []FsObject{
+            {otype: FS_DIR, name: "sub", size: 4096, time: testTime("2024-01-02T11:00:00Z")},
+            {otype: FS_FILE, name: "a-very-long-name-truncated-by-apache.tar.gz", size: 524288, time: testTime("2024-01-01T10:00:00Z")},
+            {otype: FS_FILE, name: "zero", size: 0, time: testTime("2024-01-01T10:00:00Z")},
+        }},
+        {"apache-pre-nosize.html", []FsObject{
+            {otype: FS_DIR, name: "dir", size: 4096, time: testTime("2024-01-02T11:00:59Z")},
+            {otype: FS_FILE, name: "f.bin", size: -1, time: testTime("2024-01-01T10:00:00Z")},
+        }},
+        {"apache-ul.html", []FsObject{
+            {otype: FS_DIR, name: "sub", size: 4096, time: epoch},
+            {otype: FS_FILE, name: "x.txt", size: -1, time: epoch},
+        }},
+    }
+    for _, test := range tests {
+        got := ParseApacheHtmlList(strings.NewReader(readTestdata(t, test.file)))
+        checkObjects(t, test.file, got, test.want)
+    }
+}
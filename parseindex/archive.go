@@ -0,0 +1,125 @@
+package parseindex
+
+// Browsing inside archives of HTTP mounts: "foo.zip" or "foo.iso" is also
+// a directory listing the archive members. Archives are read with HTTP
+// range requests, never downloaded as a whole.
+
+import "cfg"
+import "ftpIO"
+import "io"
+import "net/http"
+import "path"
+import "strings"
+
+// Directory reads are small, fetch bigger blocks and keep them
+const ARCHIVE_BLOCK_SIZE = 64 * 1024
+
+var errArchiveNotFound = &ReplyError{Code: 550, Text: "No such file or directory in archive."}
+
+// Archive types browsable as directories, by lowercase extension
+var archiveTypes = map[string]bool{
+    ".zip": true,
+    ".iso": true,
+}
+
+// io.ReaderAt on a remote file, with a block cache
+type httpRangeReader struct {
+    host string
+    filePath string
+    size int64
+    blocks map[int64][]byte
+}
+
+func (r *httpRangeReader) block(blockNum int64) ([]byte, error) {
+    if data, exists := r.blocks[blockNum]; exists {
+        return data, nil
+    }
+    offset := blockNum * ARCHIVE_BLOCK_SIZE
+    length := int64(ARCHIVE_BLOCK_SIZE)
+    if offset + length > r.size {
+        length = r.size - offset
+    }
+    var resp *http.Response
+    if err := ftpIO.OpenUrlRange(r.host, r.filePath, offset, length, &resp); err != nil {
+        return nil, err
+    }
+    data, err := io.ReadAll(io.LimitReader(resp.Body, length))
+    ftpIO.CloseUrl(resp)
+    if err != nil {
+        return nil, err
+    }
+    r.blocks[blockNum] = data
+    return data, nil
+}
+
+func (r *httpRangeReader) ReadAt(p []byte, off int64) (int, error) {
+    var n int
+    for n < len(p) {
+        if off + int64(n) >= r.size {
+            return n, io.EOF
+        }
+        blockNum := (off + int64(n)) / ARCHIVE_BLOCK_SIZE
+        data, err := r.block(blockNum)
+        if err != nil {
+            return n, err
+        }
+        blockOff := off + int64(n) - blockNum * ARCHIVE_BLOCK_SIZE
+        if blockOff >= int64(len(data)) {
+            return n, io.ErrUnexpectedEOF
+        }
+        n += copy(p[n:], data[blockOff:])
+    }
+    return n, nil
+}
+
+// Split fullPath in the archive path and the path inside the archive.
+// Only the first archive component is considered, archives are not nested.
+func splitArchivePath(mount cfg.Mount, fullPath string) (string, string, bool) {
+    if mount.Scheme != "http" || mount.Archives != true {
+        return "", "", false
+    }
+    pieces := strings.Split(fullPath, "/")
+    for i, piece := range pieces {
+        if i > 0 && archiveTypes[strings.ToLower(path.Ext(piece))] {
+            return strings.Join(pieces[:i + 1], "/"), strings.Join(pieces[i + 1:], "/"), true
+        }
+    }
+    return "", "", false
+}
+
+// List the directory innerPath ("" for the root) of an archive
+func listArchive(mount cfg.Mount, archivePath string, innerPath string) (FsObjectSlice, error) {
+    // All range requests on one mirror
+    host, err := pickHost(mount)
+    if err != nil {
+        return nil, err
+    }
+    mount.Host = host
+    if strings.ToLower(path.Ext(archivePath)) == ".iso" {
+        return listIso(mount, archivePath, innerPath)
+    }
+    return listZip(mount, archivePath, innerPath)
+}
+
+// Open the member innerPath of an archive
+func openArchiveMember(mount cfg.Mount, archivePath string, innerPath string, offset int64) (io.ReadCloser, error) {
+    host, err := pickHost(mount)
+    if err != nil {
+        return nil, err
+    }
+    mount.Host = host
+    if strings.ToLower(path.Ext(archivePath)) == ".iso" {
+        return openIsoMember(mount, archivePath, innerPath, offset)
+    }
+    return openZipMember(mount, archivePath, innerPath, offset)
+}
+
+// Return a reader on a remote archive
+func openArchive(mount cfg.Mount, archivePath string) (*httpRangeReader, error) {
+    size, err := ftpIO.UrlSize(mount.Host, archivePath)
+    if err != nil {
+        return nil, err
+    }
+    return &httpRangeReader{host: mount.Host, filePath: archivePath, size: size, blocks: make(map[int64][]byte)}, nil
+}
+
@@ -0,0 +1,172 @@
+package parseindex
+
+import "archive/zip"
+import "bytes"
+import "cfg"
+import "io"
+import "net/http"
+import "net/http/httptest"
+import "strings"
+import "testing"
+import "time"
+
+// Serve files by path with range support, counting the bytes sent
+func newArchiveServer(t *testing.T, files map[string][]byte, sent *int64) (cfg.Mount) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        data, exists := files[r.URL.Path]
+        if exists != true {
+            http.NotFound(w, r)
+            return
+        }
+        counter := &countingWriter{ResponseWriter: w, sent: sent}
+        http.ServeContent(counter, r, r.URL.Path, time.Time{}, bytes.NewReader(data))
+    }))
+    t.Cleanup(server.Close)
+    host := strings.TrimPrefix(server.URL, "http://")
+    return cfg.Mount{Prefix: "/m", Scheme: "http", Host: host, Hosts: []string{host}, Archives: true}
+}
+
+type countingWriter struct {
+    http.ResponseWriter
+    sent *int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+    *c.sent += int64(len(p))
+    return c.ResponseWriter.Write(p)
+}
+
+func readMember(t *testing.T, r io.ReadCloser, err error) (string) {
+    t.Helper()
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer r.Close()
+    data, err := io.ReadAll(r)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return string(data)
+}
+
+func TestZipArchive(t *testing.T) {
+    var buf bytes.Buffer
+    zw := zip.NewWriter(&buf)
+    modified := time.Date(2024, time.January, 2, 3, 4, 6, 0, time.UTC)
+    members := []struct {
+        name string
+        method uint16
+        data string
+    }{
+        {"readme.txt", zip.Store, "hello\n"},
+        {"dir/big.txt", zip.Deflate, strings.Repeat("0123456789", 1000)},
+        {"dir/sub/deep.txt", zip.Deflate, "deep\n"},
+    }
+    for _, member := range members {
+        w, err := zw.CreateHeader(&zip.FileHeader{Name: member.name, Method: member.method, Modified: modified})
+        if err != nil {
+            t.Fatal(err)
+        }
+        w.Write([]byte(member.data))
+    }
+    // A large stored member, never fetched by the listing
+    w, _ := zw.CreateHeader(&zip.FileHeader{Name: "padding.bin", Method: zip.Store, Modified: modified})
+    w.Write(make([]byte, 1024 * 1024))
+    zw.Close()
+
+    var sent int64
+    mount := newArchiveServer(t, map[string][]byte{"/m/a.zip": buf.Bytes()}, &sent)
+
+    objects, err := listZip(mount, "/m/a.zip", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    checkObjects(t, "zip root", objects, []FsObject{
+        {otype: FS_FILE, name: "readme.txt", size: 6, time: modified},
+        {otype: FS_DIR, name: "dir", size: 4096, time: modified},
+        {otype: FS_FILE, name: "padding.bin", size: 1024 * 1024, time: modified},
+    })
+    if sent >= 1024 * 1024 {
+        t.Errorf("listing fetched %d bytes, the whole archive", sent)
+    }
+    objects, err = listZip(mount, "/m/a.zip", "dir")
+    if err != nil {
+        t.Fatal(err)
+    }
+    checkObjects(t, "zip dir", objects, []FsObject{
+        {otype: FS_FILE, name: "big.txt", size: 10000, time: modified},
+        {otype: FS_DIR, name: "sub", size: 4096, time: modified},
+    })
+    if _, err = listZip(mount, "/m/a.zip", "nodir"); err != errArchiveNotFound {
+        t.Errorf("missing directory gives %v", err)
+    }
+
+    r, err := openZipMember(mount, "/m/a.zip", "readme.txt", 0)
+    if got := readMember(t, r, err); got != "hello\n" {
+        t.Errorf("stored member is %q", got)
+    }
+    r, err = openZipMember(mount, "/m/a.zip", "dir/big.txt", 5)
+    if got := readMember(t, r, err); got != members[1].data[5:] {
+        t.Errorf("deflated member from 5 is %d bytes", len(got))
+    }
+    if _, err = openZipMember(mount, "/m/a.zip", "dir", 0); err != errArchiveNotFound {
+        t.Errorf("directory member gives %v", err)
+    }
+    if _, err = listZip(mount, "/m/missing.zip", ""); err == nil {
+        t.Errorf("missing archive listed")
+    }
+}
+
+func TestIsoImage(t *testing.T) {
+    var sent int64
+    mount := newArchiveServer(t, map[string][]byte{"/m/rr.iso": []byte(readTestdata(t, "rr.iso"))}, &sent)
+    imageTime := time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+    objects, err := listIso(mount, "/m/rr.iso", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    checkObjects(t, "iso root", objects, []FsObject{
+        {otype: FS_FILE, name: "alpha.txt", size: 6, time: imageTime},
+        {otype: FS_DIR, name: "Sub Dir", size: 2048, time: imageTime},
+    })
+    if _, err = listIso(mount, "/m/rr.iso", "alpha.txt"); err != errArchiveNotFound {
+        t.Errorf("listing a file gives %v", err)
+    }
+
+    big := make([]byte, 10240)
+    for i := range big {
+        big[i] = byte(i % 256)
+    }
+    r, err := openIsoMember(mount, "/m/rr.iso", "Sub Dir/big-file.bin", 0)
+    if got := readMember(t, r, err); got != string(big) {
+        t.Errorf("member is %d bytes", len(got))
+    }
+    r, err = openIsoMember(mount, "/m/rr.iso", "Sub Dir/big-file.bin", 3000)
+    if got := readMember(t, r, err); got != string(big[3000:]) {
+        t.Errorf("member from 3000 is %d bytes", len(got))
+    }
+    if _, err = openIsoMember(mount, "/m/rr.iso", "Sub Dir", 0); err != errArchiveNotFound {
+        t.Errorf("opening a directory gives %v", err)
+    }
+    if _, err = openIsoMember(mount, "/m/rr.iso", "missing", 0); err != errArchiveNotFound {
+        t.Errorf("missing member gives %v", err)
+    }
+}
+
+func TestSplitArchivePath(t *testing.T) {
+    mount := cfg.Mount{Prefix: "/m", Scheme: "http"}
+    // Off by default, "foo.iso/" may be a real directory
+    if _, _, ret := splitArchivePath(mount, "/m/foo.iso/a"); ret == true {
+        t.Errorf("archive path split without the archives option")
+    }
+    mount.Archives = true
+    archivePath, innerPath, ret := splitArchivePath(mount, "/m/d/foo.ISO/a/b.zip")
+    if ret != true || archivePath != "/m/d/foo.ISO" || innerPath != "a/b.zip" {
+        t.Errorf("split is %q %q %v", archivePath, innerPath, ret)
+    }
+    mount.Scheme = "s3"
+    if _, _, ret = splitArchivePath(mount, "/m/foo.zip"); ret == true {
+        t.Errorf("archive path split on an s3 mount")
+    }
+}
@@ -0,0 +1,180 @@
+package parseindex
+
+// Listing cache shared by all sessions, with a TTL per mount and an LRU
+// bound on the number of entries. Concurrent requests for the same
+// directory are coalesced into one upstream fetch. Expired entries are
+// kept for the mount stale-if-error window, and served if the upstream is
+// unavailable.
+// Expired entries are revalidated with the ETag and Last-Modified of the
+// index page when the backend supports it.
+
+import "container/list"
+import "errors"
+import "fmt"
+import "cfg"
+import "metrics"
+import "path"
+import "strings"
+import "sync"
+import "time"
+
+const STALE_LISTING_WARNING = "stale listing, upstream unavailable"
+
+// Returned by a fetch when the upstream listing did not change
+var errNotModified = errors.New("not modified")
+
+// Validators of an upstream index page
+type listingValidators struct {
+    etag string
+    lastModified string
+}
+
+// Backends able to skip unchanged listings
+type conditionalLister interface {
+    ListIf(mount cfg.Mount, dirName string, validators *listingValidators) (FsObjectSlice, error)
+}
+
+type listingCacheEntry struct {
+    key string
+    dirName string
+    objects FsObjectSlice
+    validators listingValidators
+    expires time.Time
+    staleUntil time.Time
+    elem *list.Element
+}
+
+// A fetch in progress, waited on by the other requests for the same key
+type listingCall struct {
+    done chan struct{}
+    objects FsObjectSlice
+    stale bool
+    err error
+}
+
+type ListingCache struct {
+    sync.Mutex
+    entries map[string]*listingCacheEntry
+    lru *list.List      // Most recently used first
+    calls map[string]*listingCall
+}
+
+var listingCache = ListingCache{
+    entries: make(map[string]*listingCacheEntry),
+    lru: list.New(),
+    calls: make(map[string]*listingCall),
+}
+
+// Cache key, the upstream URL of the directory
+func listingCacheKey(mount cfg.Mount, dirName string) (string) {
+    if mount.Scheme == "http" {
+        return fmt.Sprintf("http://%s%s", mount.Host, dirName)
+    }
+    return fmt.Sprintf("%s://%s%s", mount.Scheme, mount.Host, path.Join("/", mount.Path, mountRelPath(mount, dirName)))
+}
+
+// Callers may append to listings, never hand out the cached slice itself
+func copyObjects(objects FsObjectSlice) (FsObjectSlice) {
+    return append(FsObjectSlice(nil), objects...)
+}
+
+// Return the listing of dirName from the cache, or from fetch().
+// fetch() gets the validators of the expired entry, if any, updates them
+// and returns errNotModified if the entry is still good.
+// The listing is stale if fetch() failed and an expired entry was used.
+func cachedList(mount cfg.Mount, dirName string, fetch func(*listingValidators) (FsObjectSlice, error)) (FsObjectSlice, bool, error) {
+    key := listingCacheKey(mount, dirName)
+
+    listingCache.Lock()
+    entry, exists := listingCache.entries[key]
+    if exists && time.Now().Before(entry.expires) {
+        listingCache.lru.MoveToFront(entry.elem)
+        objects := copyObjects(entry.objects)
+        listingCache.Unlock()
+        metrics.Inc("ftproxy_listing_cache_hits_total")
+        return objects, false, nil
+    }
+    call, exists := listingCache.calls[key]
+    if exists {
+        listingCache.Unlock()
+        <-call.done
+        return copyObjects(call.objects), call.stale, call.err
+    }
+    call = &listingCall{done: make(chan struct{})}
+    listingCache.calls[key] = call
+    var validators listingValidators
+    if entry, exists = listingCache.entries[key]; exists {
+        validators = entry.validators
+    }
+    listingCache.Unlock()
+
+    metrics.Inc("ftproxy_listing_cache_misses_total")
+    call.objects, call.err = fetch(&validators)
+
+    listingCache.Lock()
+    delete(listingCache.calls, key)
+    if call.err == errNotModified {
+        entry, exists = listingCache.entries[key]
+        if exists {
+            fmt.Printf("Listing of %s not modified upstream\n", dirName)
+            metrics.Inc("ftproxy_listing_cache_revalidated_total")
+            call.objects = entry.objects
+            call.err = nil
+        } else {
+            // Evicted meanwhile, next request will fetch it again
+            call.err = errors.New("listing evicted during revalidation, please retry")
+        }
+    }
+    if call.err == nil && mount.ListingTtl > 0 {
+        ttl := time.Duration(mount.ListingTtl * float64(time.Second))
+        staleTtl := time.Duration(mount.StaleIfError * float64(time.Second))
+        listingCache.store(key, dirName, call.objects, validators, ttl, staleTtl)
+    } else if call.err != nil && retriable(call.err) {
+        // Not for 4xx, a directory removed upstream is gone
+        entry, exists = listingCache.entries[key]
+        if exists && time.Now().Before(entry.staleUntil) {
+            fmt.Printf("WARNING! Upstream failed (%s), serving stale listing of %s\n", call.err.Error(), dirName)
+            metrics.Inc("ftproxy_listing_cache_stale_total")
+            call.objects = entry.objects
+            call.stale = true
+            call.err = nil
+        }
+    }
+    listingCache.Unlock()
+    close(call.done)
+    return copyObjects(call.objects), call.stale, call.err
+}
+
+// Must be called locked
+func (c *ListingCache) store(key string, dirName string, objects FsObjectSlice, validators listingValidators, ttl time.Duration, staleTtl time.Duration) {
+    if entry, exists := c.entries[key]; exists {
+        c.lru.Remove(entry.elem)
+        delete(c.entries, key)
+    }
+    expires := time.Now().Add(ttl)
+    entry := &listingCacheEntry{key: key, dirName: dirName, objects: objects, validators: validators, expires: expires, staleUntil: expires.Add(staleTtl)}
+    entry.elem = c.lru.PushFront(entry)
+    c.entries[key] = entry
+    for c.lru.Len() > cfg.GetListingCacheEntries() {
+        oldest := c.lru.Back().Value.(*listingCacheEntry)
+        c.lru.Remove(oldest.elem)
+        delete(c.entries, oldest.key)
+    }
+}
+
+// Drop cached listings of dirPrefix and below ("/" for everything), return how many
+func PurgeListings(dirPrefix string) (int) {
+    dirPrefix = strings.TrimRight(path.Clean("/" + dirPrefix), "/")
+    listingCache.Lock()
+    defer listingCache.Unlock()
+    purged := 0
+    for key, entry := range listingCache.entries {
+        if strings.HasPrefix(entry.dirName + "/", dirPrefix + "/") {
+            listingCache.lru.Remove(entry.elem)
+            delete(listingCache.entries, key)
+            purged++
+        }
+    }
+    fmt.Printf("Purged %d cached listings below %s/\n", purged, dirPrefix)
+    return purged
+}
@@ -0,0 +1,94 @@
+package parseindex
+
+// Caddy file_server browse: HTML rows with the exact size in a data-size
+// attribute and the time in a <time datetime>, or the same entries as
+// json when asked with "Accept: application/json"
+
+import "encoding/json"
+import "fmt"
+import "golang.org/x/net/html"
+import "io"
+import "strconv"
+import "strings"
+import "time"
+
+// Entry of the json listing
+type caddyJsonEntry struct {
+    Name string `json:"name"`      // "name/" for directories
+    Size int64 `json:"size"`
+    Url string `json:"url"`
+    ModTime time.Time `json:"mod_time"`
+    IsDir bool `json:"is_dir"`
+}
+
+func ParseCaddyJsonList(r io.Reader) ([]FsObject) {
+    var entries []caddyJsonEntry
+    var objects []FsObject
+    if err := json.NewDecoder(r).Decode(&entries); err != nil {
+        fmt.Println("Invalid caddy json listing:", err.Error())
+        return objects
+    }
+    for _, entry := range entries {
+        object := FsObject{name: strings.TrimSuffix(entry.Name, "/"), time: entry.ModTime.UTC(), size: entry.Size, otype: FS_FILE}
+        if entry.IsDir {
+            object.otype = FS_DIR
+            object.size = 4096 /* XXX fake size */
+        }
+        objects = append(objects, object)
+    }
+    return objects
+}
+
+func ParseCaddyHtmlList(r io.Reader) ([]FsObject) {
+    z := html.NewTokenizer(r)
+
+    var objects []FsObject
+    var curObj *FsObject
+
+    for {
+    tt := z.Next()
+
+    switch tt {
+    case html.ErrorToken:
+        // End of the document, we're done
+        return objects
+    case html.StartTagToken:
+        t := z.Token()
+        switch t.Data {
+        case "tr":
+            curObj = nil
+        case "a":
+            if curObj != nil {
+                break
+            }
+            // The "Up" link is "..", entries are "./name"
+            if object, ok := hrefEntry(getTokenAttr(&t, "href")); ok {
+                curObj = &object
+            }
+        case "td":
+            dataSize := getTokenAttr(&t, "data-size")
+            if curObj == nil || dataSize == "" || curObj.otype != FS_FILE {
+                break
+            }
+            if size, err := strconv.ParseInt(dataSize, 10, 64); err == nil {
+                curObj.size = size
+            }
+        case "time":
+            if curObj == nil {
+                break
+            }
+            if tim, err := time.Parse(time.RFC3339, getTokenAttr(&t, "datetime")); err == nil {
+                curObj.time = tim.UTC()
+            }
+        }
+    case html.EndTagToken:
+        t := z.Token()
+        if t.Data == "tr" {
+            if curObj != nil {
+                objects = append(objects, *curObj)
+            }
+            curObj = nil
+        }
+    }
+    }
+}
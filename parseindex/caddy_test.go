@@ -0,0 +1,20 @@
+package parseindex
+
+import "strings"
+import "testing"
+
+func TestParseCaddyHtmlList(t *testing.T) {
+    got := ParseCaddyHtmlList(strings.NewReader(readTestdata(t, "caddy.html")))
+    checkObjects(t, "caddy.html", got, []FsObject{
+        {otype: FS_DIR, name: "sub", size: 4096, time: testTime("2024-01-02T11:00:00Z")},
+        {otype: FS_FILE, name: "big.iso", size: 1288490188, time: testTime("2024-01-01T10:00:33Z")},
+    })
+}
+
+func TestParseCaddyJsonList(t *testing.T) {
+    got := ParseCaddyJsonList(strings.NewReader(readTestdata(t, "caddy.json")))
+    checkObjects(t, "caddy.json", got, []FsObject{
+        {otype: FS_DIR, name: "sub", size: 4096, time: testTime("2024-01-02T11:00:00.5Z")},
+        {otype: FS_FILE, name: "big.iso", size: 1288490188, time: testTime("2024-01-01T10:00:33.123+01:00")},
+    })
+}
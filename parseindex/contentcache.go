@@ -0,0 +1,208 @@
+package parseindex
+
+// RETR through the disk content cache: a cached file is served if its
+// validators still match the upstream ones, otherwise the upstream file
+// is sent to the client and stored at the same time. If the upstream is
+// down, a cached file validated less than staleIfError ago is served.
+// HTTP mounts revalidate with a conditional GET, whose body is used
+// directly if the file changed.
+
+import "fmt"
+import "cfg"
+import "contentcache"
+import "ftpIO"
+import "io"
+import "metrics"
+import "net/http"
+import "os"
+import "path"
+import "time"
+
+const STALE_WARNING = "stale copy, upstream unavailable"
+
+// Return the current validators of an upstream file from its listing,
+// and whether the upstream failed to answer
+func fileValidators(mount cfg.Mount, filePath string) (contentcache.Meta, bool, bool) {
+    meta := contentcache.Meta{Url: listingCacheKey(mount, filePath), Size: -1}
+    dirName, fileName := path.Split(filePath)
+    objects, stale, err := listFSObjects(dirName)
+    if err != nil || stale == true {
+        return meta, false, true
+    }
+    for _, object := range objects {
+        if object.name == fileName && object.otype == FS_FILE && object.virtual != true {
+            meta.ETag = object.etag
+            meta.LastModified = object.time.UTC().Format(http.TimeFormat)
+            meta.Size = object.size
+            return meta, true, false
+        }
+    }
+    return meta, false, false
+}
+
+func validatorsMatch(cached contentcache.Meta, current contentcache.Meta) (bool) {
+    if cached.ETag != "" && current.ETag != "" {
+        return cached.ETag == current.ETag
+    }
+    if current.Size >= 0 && cached.Size != current.Size {
+        return false
+    }
+    return cached.LastModified != "" && cached.LastModified == current.LastModified
+}
+
+// Upstream data copied to a cache fill while it is read
+type cacheFillReader struct {
+    upstream io.ReadCloser
+    fill *contentcache.Fill
+}
+
+func (c *cacheFillReader) Read(p []byte) (int, error) {
+    n, err := c.upstream.Read(p)
+    c.fill.Write(p[:n])
+    if err == io.EOF {
+        c.fill.Finish(true)
+    } else if err != nil {
+        c.fill.Finish(false)
+    }
+    return n, err
+}
+
+func (c *cacheFillReader) Close() (error) {
+    // No-op if already finished
+    c.fill.Finish(false)
+    return c.upstream.Close()
+}
+
+// A cached file served while the upstream is down
+type staleFile struct {
+    *os.File
+}
+
+func (s staleFile) Warning() (string) {
+    return STALE_WARNING
+}
+
+func openCached(mount cfg.Mount, backend Backend, filePath string, offset int64) (io.ReadCloser, error) {
+    if mount.Scheme == "http" {
+        // Listings of autoindex pages lack exact sizes and times
+        return openCachedHttp(mount, backend, filePath, offset)
+    }
+    current, ret, down := fileValidators(mount, filePath)
+    if ret != true {
+        if down == true {
+            if file, ret := openStale(mount, current.Url, offset); ret == true {
+                return file, nil
+            }
+        }
+        fmt.Printf("Content cache: no validators for %s, not caching\n", filePath)
+        return backend.Open(mount, filePath, offset)
+    }
+    cached, hit := contentcache.Lookup(current.Url)
+    if hit {
+        if validatorsMatch(cached, current) {
+            file, err := contentcache.Open(current.Url, offset)
+            if err == nil {
+                fmt.Printf("Content cache: hit for %s\n", current.Url)
+                metrics.Inc("ftproxy_content_cache_hits_total")
+                contentcache.Revalidate(current.Url)
+                return file, nil
+            }
+        } else {
+            fmt.Printf("Content cache: %s changed upstream\n", current.Url)
+            contentcache.Remove(current.Url)
+        }
+    }
+    metrics.Inc("ftproxy_content_cache_misses_total")
+    if offset > 0 {
+        // Partial contents cannot fill the cache
+        return backend.Open(mount, filePath, offset)
+    }
+    upstream, err := backend.Open(mount, filePath, 0)
+    if err != nil {
+        return nil, err
+    }
+    fill := contentcache.StartFill(current)
+    if fill == nil {
+        return upstream, nil
+    }
+    return &cacheFillReader{upstream: upstream, fill: fill}, nil
+}
+
+func openCachedHttp(mount cfg.Mount, backend Backend, filePath string, offset int64) (io.ReadCloser, error) {
+    url := listingCacheKey(mount, filePath)
+    cached, hit := contentcache.Lookup(url)
+    if hit != true && offset > 0 {
+        // Partial contents cannot fill the cache
+        metrics.Inc("ftproxy_content_cache_misses_total")
+        return backend.Open(mount, filePath, offset)
+    }
+    var resp *http.Response
+    set := getEndpointSet(mount)
+    err := set.do(nil, func(e *endpoint) (error) {
+        err := ftpIO.OpenUrlIf(e.host, filePath, "", cached.ETag, cached.LastModified, &resp)
+        set.done(e, err, false)
+        return err
+    })
+    if err != nil {
+        if retriable(err) {
+            if file, ret := openStale(mount, url, offset); ret == true {
+                return file, nil
+            }
+        }
+        return nil, err
+    }
+    if resp.StatusCode == http.StatusNotModified {
+        file, err := contentcache.Open(url, offset)
+        if err == nil {
+            fmt.Printf("Content cache: hit for %s (not modified)\n", url)
+            metrics.Inc("ftproxy_content_cache_hits_total")
+            contentcache.Revalidate(url)
+            return file, nil
+        }
+        // Evicted since the lookup
+        return backend.Open(mount, filePath, offset)
+    }
+    if hit {
+        fmt.Printf("Content cache: %s changed upstream\n", url)
+        contentcache.Remove(url)
+    }
+    metrics.Inc("ftproxy_content_cache_misses_total")
+    if offset > 0 {
+        ftpIO.CloseUrl(resp)
+        return backend.Open(mount, filePath, offset)
+    }
+    current := contentcache.Meta{
+        Url: url,
+        ETag: resp.Header.Get("ETag"),
+        LastModified: resp.Header.Get("Last-Modified"),
+        Size: resp.ContentLength,
+    }
+    if current.ETag == "" && current.LastModified == "" {
+        fmt.Printf("Content cache: no validators for %s, not caching\n", filePath)
+        return resp.Body, nil
+    }
+    fill := contentcache.StartFill(current)
+    if fill == nil {
+        return resp.Body, nil
+    }
+    return &cacheFillReader{upstream: resp.Body, fill: fill}, nil
+}
+
+// Serve a cached copy validated less than staleIfError ago
+func openStale(mount cfg.Mount, url string, offset int64) (io.ReadCloser, bool) {
+    cached, hit := contentcache.Lookup(url)
+    if hit != true || mount.StaleIfError <= 0 {
+        return nil, false
+    }
+    staleTtl := time.Duration(mount.StaleIfError * float64(time.Second))
+    if time.Since(cached.Validated) > staleTtl {
+        return nil, false
+    }
+    file, err := contentcache.Open(url, offset)
+    if err != nil {
+        return nil, false
+    }
+    fmt.Printf("WARNING! Upstream unavailable, serving stale copy of %s\n", url)
+    metrics.Inc("ftproxy_content_cache_stale_total")
+    return staleFile{file}, true
+}
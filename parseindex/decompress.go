@@ -0,0 +1,225 @@
+package parseindex
+
+// Transparent decompression: "Packages" is listed next to "Packages.gz" and
+// RETR of it streams the decompressed upstream file. The uncompressed size
+// is only known once the file has been retrieved in full, it is then cached.
+
+import "bytes"
+import "compress/bzip2"
+import "compress/gzip"
+import "errors"
+import "fmt"
+import "cfg"
+import "io"
+import "os/exec"
+import "path"
+import "strings"
+import "sync"
+
+// Compressed suffixes, in order of preference when several exist
+var decompressSuffixes = []string{".gz", ".bz2", ".xz", ".zst"}
+
+// Formats without a decoder in the standard library use these commands
+var decompressCommands = map[string][]string{
+    ".xz": {"xz", "-dc"},
+    ".zst": {"zstd", "-dc"},
+}
+
+const DECOMPRESSED_SIZES_MAX = 10000
+
+// Uncompressed sizes, by compressed file path, time and size
+type DecompressedSizes struct {
+    sync.Mutex
+    sizes map[string]int64
+}
+
+var decompressedSizes = DecompressedSizes{sizes: make(map[string]int64)}
+
+func decompressedSizeKey(compressedPath string, compressed FsObject) (string) {
+    return fmt.Sprintf("%s|%d|%d", compressedPath, compressed.time.Unix(), compressed.size)
+}
+
+func getDecompressedSize(key string) (int64) {
+    decompressedSizes.Lock()
+    defer decompressedSizes.Unlock()
+    size, exists := decompressedSizes.sizes[key]
+    if exists != true {
+        return -1
+    }
+    return size
+}
+
+func setDecompressedSize(key string, size int64) {
+    decompressedSizes.Lock()
+    defer decompressedSizes.Unlock()
+    if len(decompressedSizes.sizes) >= DECOMPRESSED_SIZES_MAX {
+        // Crude bound, sizes are cheap to learn again
+        decompressedSizes.sizes = make(map[string]int64)
+    }
+    decompressedSizes.sizes[key] = size
+}
+
+// Add uncompressed names for compressed files, real files always win
+func addDecompressedNames(dirName string, objects FsObjectSlice) (FsObjectSlice) {
+    names := make(map[string]bool)
+    for _, object := range objects {
+        names[object.name] = true
+    }
+    for _, object := range objects {
+        if object.otype != FS_FILE {
+            continue
+        }
+        for _, suffix := range decompressSuffixes {
+            if !strings.HasSuffix(object.name, suffix) {
+                continue
+            }
+            name := strings.TrimSuffix(object.name, suffix)
+            if name == "" || names[name] {
+                continue
+            }
+            names[name] = true
+            key := decompressedSizeKey(path.Join(dirName, object.name), object)
+            objects = append(objects, FsObject{otype: FS_FILE, name: name, time: object.time, size: getDecompressedSize(key), virtual: true})
+        }
+    }
+    return objects
+}
+
+// Return the compressed file filePath is a virtual name for
+func findCompressedFile(filePath string) (string, bool) {
+    dirName, fileName := path.Split(filePath)
+    objects, err := ListFSObjects(dirName)
+    if err != nil {
+        return "", false
+    }
+    names := make(map[string]bool)
+    for _, object := range objects {
+        if object.otype == FS_FILE && object.virtual != true {
+            names[object.name] = true
+        }
+    }
+    if names[fileName] {
+        return "", false
+    }
+    for _, suffix := range decompressSuffixes {
+        if names[fileName + suffix] {
+            return filePath + suffix, true
+        }
+    }
+    return "", false
+}
+
+// Decompressed stream, remembers the size once read in full
+type decompressReader struct {
+    compressed io.ReadCloser
+    r io.Reader
+    cmd *exec.Cmd
+    stderr bytes.Buffer
+    waited bool
+    waitErr error
+    sizeKey string
+    count int64
+}
+
+// Reap the external decompressor once, a failure is an error of the stream
+func (d *decompressReader) wait() (error) {
+    if d.cmd != nil && d.waited != true {
+        d.waited = true
+        if err := d.cmd.Wait(); err != nil {
+            d.waitErr = fmt.Errorf("%s: %s: %s", d.cmd.Args[0], err.Error(), strings.TrimSpace(d.stderr.String()))
+        }
+    }
+    return d.waitErr
+}
+
+func (d *decompressReader) Read(p []byte) (int, error) {
+    n, err := d.r.Read(p)
+    d.count += int64(n)
+    if err == io.EOF {
+        if waitErr := d.wait(); waitErr != nil {
+            d.sizeKey = ""
+            return n, waitErr
+        }
+    }
+    if err == io.EOF && d.sizeKey != "" {
+        setDecompressedSize(d.sizeKey, d.count)
+        d.sizeKey = ""
+    }
+    return n, err
+}
+
+func (d *decompressReader) Close() (error) {
+    err := d.compressed.Close()
+    if d.cmd != nil {
+        if closer, ok := d.r.(io.Closer); ok {
+            closer.Close()
+        }
+        // Closed early the decompressor dies of SIGPIPE, not worth reporting
+        d.wait()
+    }
+    return err
+}
+
+func openDecompressed(mount cfg.Mount, filePath string, compressedPath string, offset int64) (io.ReadCloser, error) {
+    backend, err := getBackend(mount)
+    if err != nil {
+        return nil, err
+    }
+    var compressedObj FsObject
+    objects, err := ListFSObjects(path.Dir(compressedPath))
+    if err != nil {
+        return nil, err
+    }
+    for _, object := range objects {
+        if object.name == path.Base(compressedPath) {
+            compressedObj = object
+        }
+    }
+    compressed, err := backend.Open(mount, compressedPath, 0)
+    if err != nil {
+        return nil, err
+    }
+    fmt.Printf("Decompressing %s for %s\n", compressedPath, filePath)
+    d := &decompressReader{compressed: compressed, sizeKey: decompressedSizeKey(compressedPath, compressedObj)}
+
+    suffix := path.Ext(compressedPath)
+    switch suffix {
+    case ".gz":
+        d.r, err = gzip.NewReader(compressed)
+    case ".bz2":
+        d.r = bzip2.NewReader(compressed)
+    default:
+        command, exists := decompressCommands[suffix]
+        if exists != true {
+            err = errors.New("unknown compression format")
+            break
+        }
+        d.cmd = exec.Command(command[0], command[1:]...)
+        d.cmd.Stdin = compressed
+        d.cmd.Stderr = &d.stderr
+        var stdout io.ReadCloser
+        stdout, err = d.cmd.StdoutPipe()
+        if err == nil {
+            err = d.cmd.Start()
+        }
+        if err != nil {
+            d.cmd = nil
+            err = &ReplyError{Code: 550, Text: fmt.Sprintf("Cannot decompress %s files.", suffix)}
+            break
+        }
+        d.r = stdout
+    }
+    if err != nil {
+        compressed.Close()
+        return nil, err
+    }
+    if offset > 0 {
+        // Compressed streams are not seekable, and the size would be wrong
+        d.sizeKey = ""
+        if _, err = io.CopyN(io.Discard, d, offset); err != nil {
+            d.Close()
+            return nil, err
+        }
+    }
+    return d, nil
+}
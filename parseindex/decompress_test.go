@@ -0,0 +1,115 @@
+package parseindex
+
+import "bytes"
+import "cfg"
+import "compress/gzip"
+import "fmt"
+import "io"
+import "os"
+import "os/exec"
+import "path/filepath"
+import "strings"
+import "testing"
+
+// Load a config with a decompressing file:// mount of dir on "/m"
+func loadDecompressConfig(t *testing.T, dir string) (cfg.Mount) {
+    confPath := filepath.Join(t.TempDir(), "ftproxy.conf")
+    conf := fmt.Sprintf(`{"maxConnections": 1, "defaultHttpIp": "127.0.0.1:1", "listenPort": "2121", "httpIps": {
+        "/m": {"url": "file://%s", "decompress": true, "listingTtl": 0}}}`, dir)
+    if err := os.WriteFile(confPath, []byte(conf), 0644); err != nil {
+        t.Fatal(err)
+    }
+    cfg.LoadConfig(confPath)
+    return cfg.GetMount("/m")
+}
+
+func writeTestFile(t *testing.T, filePath string, data []byte) {
+    if err := os.WriteFile(filePath, data, 0644); err != nil {
+        t.Fatal(err)
+    }
+}
+
+// Compress data with an external command, skipping the test without it
+func compressWith(t *testing.T, command string, data string) ([]byte) {
+    if _, err := exec.LookPath(command); err != nil {
+        t.Skipf("no %s command", command)
+    }
+    cmd := exec.Command(command, "-c")
+    cmd.Stdin = strings.NewReader(data)
+    out, err := cmd.Output()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return out
+}
+
+func TestDecompressGzip(t *testing.T) {
+    dir := t.TempDir()
+    var buf bytes.Buffer
+    gw := gzip.NewWriter(&buf)
+    gw.Write([]byte("hello\n"))
+    gw.Close()
+    writeTestFile(t, filepath.Join(dir, "ok.gz"), buf.Bytes())
+    writeTestFile(t, filepath.Join(dir, "real.gz"), buf.Bytes())
+    writeTestFile(t, filepath.Join(dir, "real"), []byte("real\n"))
+    writeTestFile(t, filepath.Join(dir, "bad.gz"), []byte("not gzip"))
+    mount := loadDecompressConfig(t, dir)
+
+    objects, err := ListFSObjects("/m")
+    if err != nil {
+        t.Fatal(err)
+    }
+    virtual := make(map[string]bool)
+    for _, object := range objects {
+        if object.virtual {
+            virtual[object.name] = true
+        }
+    }
+    if virtual["ok"] != true || virtual["bad"] != true || virtual["real"] {
+        t.Errorf("virtual names are %v", virtual)
+    }
+    if compressedPath, ok := findCompressedFile("/m/real"); ok {
+        t.Errorf("real file maps to %s", compressedPath)
+    }
+
+    r, err := openDecompressed(mount, "/m/ok", "/m/ok.gz", 0)
+    if got := readMember(t, r, err); got != "hello\n" {
+        t.Errorf("ok is %q", got)
+    }
+    r, err = openDecompressed(mount, "/m/ok", "/m/ok.gz", 2)
+    if got := readMember(t, r, err); got != "llo\n" {
+        t.Errorf("ok from 2 is %q", got)
+    }
+    // Learnt from the full read
+    objects, _ = ListFSObjects("/m")
+    for _, object := range objects {
+        if object.name == "ok" && object.size != 6 {
+            t.Errorf("ok listed with size %d", object.size)
+        }
+    }
+    if _, err = openDecompressed(mount, "/m/bad", "/m/bad.gz", 0); err == nil {
+        t.Errorf("invalid gzip opened")
+    }
+}
+
+func TestDecompressCommand(t *testing.T) {
+    dir := t.TempDir()
+    writeTestFile(t, filepath.Join(dir, "ok.xz"), compressWith(t, "xz", "hello\n"))
+    writeTestFile(t, filepath.Join(dir, "bad.xz"), bytes.Repeat([]byte("not xz"), 100))
+    mount := loadDecompressConfig(t, dir)
+
+    r, err := openDecompressed(mount, "/m/ok", "/m/ok.xz", 0)
+    if got := readMember(t, r, err); got != "hello\n" {
+        t.Errorf("ok is %q", got)
+    }
+    // The decompressor failure is the error of the stream, not a short file
+    r, err = openDecompressed(mount, "/m/bad", "/m/bad.xz", 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = io.ReadAll(r)
+    r.Close()
+    if err == nil || strings.Contains(err.Error(), "xz") != true {
+        t.Errorf("corrupt xz read gives %v", err)
+    }
+}
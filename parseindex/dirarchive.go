@@ -0,0 +1,240 @@
+package parseindex
+
+// Virtual "<dir>.tar", "<dir>.tar.gz" and "<dir>.zip" files, built on the fly
+// from the listings of a directory tree, streamed while the files are fetched
+
+import "archive/tar"
+import "archive/zip"
+import "compress/gzip"
+import "errors"
+import "fmt"
+import "cfg"
+import "io"
+import "os"
+import "path"
+import "strings"
+import "time"
+
+var dirArchiveSuffixes = []string{".tar.gz", ".tar", ".zip"}
+
+// A file or directory to put in the archive
+type dirArchiveEntry struct {
+    relPath string      // Path inside the archive
+    object FsObject
+}
+
+// The file bytes put in an archive, listings do not always give sizes
+// so the mount limit is also checked while streaming
+type archiveBudget struct {
+    used int64
+    max int64
+}
+
+func errArchiveTooLarge(max int64) (error) {
+    return &ReplyError{Code: 552, Text: fmt.Sprintf("Directory larger than %d bytes, cannot archive.", max)}
+}
+
+type budgetReader struct {
+    r io.Reader
+    budget *archiveBudget
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+    n, err := b.r.Read(p)
+    b.budget.used += int64(n)
+    if b.budget.used > b.budget.max {
+        return n, errArchiveTooLarge(b.budget.max)
+    }
+    return n, err
+}
+
+// Return the directory and archive format if filePath names a virtual archive
+func splitDirArchivePath(filePath string) (string, string, bool) {
+    for _, suffix := range dirArchiveSuffixes {
+        if strings.HasSuffix(filePath, suffix) {
+            dirPath := strings.TrimSuffix(filePath, suffix)
+            if path.Base(dirPath) != "" && path.Base(dirPath) != "/" {
+                return dirPath, suffix, true
+            }
+        }
+    }
+    return "", "", false
+}
+
+// Check that filePath is not a real file but sits next to a directory
+func isDirArchive(filePath string, dirPath string) (bool) {
+    parentName, fileName := path.Split(filePath)
+    objects, err := ListFSObjects(parentName)
+    if err != nil {
+        return false
+    }
+    isDir := false
+    for _, object := range objects {
+        if object.name == fileName && object.otype == FS_FILE {
+            return false
+        }
+        if object.name == path.Base(dirPath) && object.otype == FS_DIR {
+            isDir = true
+        }
+    }
+    return isDir
+}
+
+// Walk the tree below dirPath, checking the mount limits
+func crawlDir(mount cfg.Mount, dirPath string, relPath string, depth int, entries []dirArchiveEntry, totalSize *int64) ([]dirArchiveEntry, error) {
+    if depth > mount.ArchiveMaxDepth {
+        return entries, &ReplyError{Code: 550, Text: fmt.Sprintf("Directory deeper than %d levels, cannot archive.", mount.ArchiveMaxDepth)}
+    }
+    objects, err := ListFSObjects(dirPath)
+    if err != nil {
+        return entries, err
+    }
+    for _, object := range objects {
+        // Uncompressed names and redirects would duplicate files
+        if object.virtual || object.link != "" {
+            continue
+        }
+        entryRelPath := path.Join(relPath, object.name)
+        entries = append(entries, dirArchiveEntry{relPath: entryRelPath, object: object})
+        if object.otype == FS_DIR {
+            entries, err = crawlDir(mount, path.Join(dirPath, object.name), entryRelPath, depth + 1, entries, totalSize)
+            if err != nil {
+                return entries, err
+            }
+        } else if object.size >= 0 {
+            // Unknown sizes are only checked while streaming
+            *totalSize += object.size
+            if *totalSize > mount.ArchiveMaxSize {
+                return entries, errArchiveTooLarge(mount.ArchiveMaxSize)
+            }
+        }
+    }
+    return entries, nil
+}
+
+// Sizes in listings may be rounded ("1.2M"), tar needs the exact size
+// before the content, so each file goes through a temporary file
+func writeTarFile(tw *tar.Writer, filePath string, header *tar.Header, budget *archiveBudget) (error) {
+    file, err := OpenFile(filePath, 0)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    spool, err := os.CreateTemp("", "ftproxy-tar-")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(spool.Name())
+    defer spool.Close()
+    header.Size, err = io.Copy(spool, &budgetReader{r: file, budget: budget})
+    if err != nil {
+        return err
+    }
+    if _, err = spool.Seek(0, io.SeekStart); err != nil {
+        return err
+    }
+    if err = tw.WriteHeader(header); err != nil {
+        return err
+    }
+    _, err = io.Copy(tw, spool)
+    return err
+}
+
+func writeTar(w io.Writer, dirPath string, baseName string, entries []dirArchiveEntry, budget *archiveBudget) (error) {
+    tw := tar.NewWriter(w)
+    err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: baseName + "/", Mode: 0755, ModTime: time.Now()})
+    for _, entry := range entries {
+        if err != nil {
+            return err
+        }
+        header := &tar.Header{Name: path.Join(baseName, entry.relPath), ModTime: entry.object.time}
+        if entry.object.otype == FS_DIR {
+            header.Typeflag = tar.TypeDir
+            header.Name += "/"
+            header.Mode = 0755
+            err = tw.WriteHeader(header)
+        } else {
+            header.Typeflag = tar.TypeReg
+            header.Mode = 0644
+            err = writeTarFile(tw, path.Join(dirPath, entry.relPath), header, budget)
+        }
+    }
+    if err != nil {
+        return err
+    }
+    return tw.Close()
+}
+
+func writeZip(w io.Writer, dirPath string, baseName string, entries []dirArchiveEntry, budget *archiveBudget) (error) {
+    zw := zip.NewWriter(w)
+    for _, entry := range entries {
+        header := &zip.FileHeader{Name: path.Join(baseName, entry.relPath), Modified: entry.object.time}
+        if entry.object.otype == FS_DIR {
+            header.Name += "/"
+            if _, err := zw.CreateHeader(header); err != nil {
+                return err
+            }
+            continue
+        }
+        // Sizes go in data descriptors, no need to know them first
+        header.Method = zip.Deflate
+        fw, err := zw.CreateHeader(header)
+        if err != nil {
+            return err
+        }
+        file, err := OpenFile(path.Join(dirPath, entry.relPath), 0)
+        if err != nil {
+            return err
+        }
+        _, err = io.Copy(fw, &budgetReader{r: file, budget: budget})
+        file.Close()
+        if err != nil {
+            return err
+        }
+    }
+    return zw.Close()
+}
+
+// Crawl dirPath then stream its archive from a goroutine
+func openDirArchive(mount cfg.Mount, dirPath string, suffix string, offset int64) (io.ReadCloser, error) {
+    var totalSize int64
+    entries, err := crawlDir(mount, dirPath, "", 1, nil, &totalSize)
+    if err != nil {
+        fmt.Println("Cannot crawl directory for archive:", err.Error())
+        return nil, err
+    }
+    fmt.Printf("Archiving %s as %s: %d entries, about %d bytes\n", dirPath, suffix, len(entries), totalSize)
+
+    pr, pw := io.Pipe()
+    go func() {
+        var err error
+        baseName := path.Base(dirPath)
+        budget := &archiveBudget{max: mount.ArchiveMaxSize}
+        switch suffix {
+        case ".tar":
+            err = writeTar(pw, dirPath, baseName, entries, budget)
+        case ".tar.gz":
+            gw := gzip.NewWriter(pw)
+            err = writeTar(gw, dirPath, baseName, entries, budget)
+            if err == nil {
+                err = gw.Close()
+            }
+        case ".zip":
+            err = writeZip(pw, dirPath, baseName, entries, budget)
+        default:
+            err = errors.New("unknown archive format")
+        }
+        if err != nil {
+            fmt.Println("Directory archive failed:", err.Error())
+        }
+        pw.CloseWithError(err)
+    }()
+    if offset > 0 {
+        // Archives are generated, not seekable
+        if _, err = io.CopyN(io.Discard, pr, offset); err != nil {
+            pr.Close()
+            return nil, err
+        }
+    }
+    return pr, nil
+}
@@ -0,0 +1,530 @@
+package parseindex
+
+// Upstream endpoints of remote mounts. Each request goes to a mirror chosen
+// by the mount balance policy and is retried on the next ones, then again
+// after a jittered exponential backoff. Each endpoint has a circuit breaker
+// opening after maxFails failures in a row: it is skipped for failTimeout
+// seconds, then a single request probes it. Mirrors failing their health
+// check are only used when no other one is left. Broken RETR streams
+// continue on another mirror, if the file there is the same version.
+
+import "context"
+import "errors"
+import "fmt"
+import "cfg"
+import "ftpIO"
+import "io"
+import "math/rand"
+import "metrics"
+import "net"
+import "net/http"
+import "sort"
+import "strings"
+import "sync"
+import "time"
+
+const (
+    BREAKER_CLOSED = 0
+    BREAKER_OPEN = 1
+    BREAKER_HALF_OPEN = 2   // One probe request in progress
+)
+
+type endpoint struct {
+    host string
+    fails int                   // Consecutive failures
+    breaker int
+    openUntil time.Time
+    unhealthy bool              // Active check
+    active int                  // Requests and transfers in progress
+}
+
+// The endpoints of a mount, with its settings
+type endpointSet struct {
+    balance string
+    maxFails int
+    failTimeout time.Duration
+    retries int
+    backoff time.Duration
+    backoffMax time.Duration
+    endpoints []*endpoint
+    next int                    // Round-robin position
+}
+
+// Endpoints are shared by the mounts using the same host
+var endpoints = struct {
+    sync.Mutex
+    byHost map[string]*endpoint
+    sets map[string]*endpointSet        // By mount prefix
+}{byHost: make(map[string]*endpoint), sets: make(map[string]*endpointSet)}
+
+func seconds(s float64) (time.Duration) {
+    return time.Duration(s * float64(time.Second))
+}
+
+// Return the endpoints of mount
+func getEndpointSet(mount cfg.Mount) (*endpointSet) {
+    endpoints.Lock()
+    defer endpoints.Unlock()
+    set, exists := endpoints.sets[mount.Prefix]
+    if exists != true {
+        set = &endpointSet{
+            balance: mount.Balance,
+            maxFails: mount.MaxFails,
+            failTimeout: seconds(mount.FailTimeout),
+            retries: mount.Retries,
+            backoff: seconds(mount.RetryBackoff),
+            backoffMax: seconds(mount.RetryBackoffMax),
+        }
+        for _, host := range mount.Hosts {
+            e, exists := endpoints.byHost[host]
+            if exists != true {
+                e = &endpoint{host: host}
+                endpoints.byHost[host] = e
+                e.publish()
+            }
+            set.endpoints = append(set.endpoints, e)
+        }
+        endpoints.sets[mount.Prefix] = set
+    }
+    return set
+}
+
+// Must be called locked
+func (e *endpoint) publish() {
+    metrics.Set(fmt.Sprintf("ftproxy_breaker_state{endpoint=%q}", e.host), int64(e.breaker))
+    if e.breaker == BREAKER_CLOSED && e.unhealthy != true {
+        metrics.Set(fmt.Sprintf("ftproxy_endpoint_up{endpoint=%q}", e.host), 1)
+    } else {
+        metrics.Set(fmt.Sprintf("ftproxy_endpoint_up{endpoint=%q}", e.host), 0)
+    }
+}
+
+// Return the endpoints to try in order: healthy ones by policy first,
+// then unhealthy ones. Open breakers are left out, unless their time is
+// up and this request can be the probe.
+func (s *endpointSet) order() ([]*endpoint) {
+    endpoints.Lock()
+    defer endpoints.Unlock()
+    now := time.Now()
+    var healthy, unhealthy []*endpoint
+    for i := range s.endpoints {
+        e := s.endpoints[i]
+        if s.balance == "round-robin" {
+            e = s.endpoints[(s.next + i) % len(s.endpoints)]
+        }
+        if e.breaker == BREAKER_HALF_OPEN || e.breaker == BREAKER_OPEN && now.Before(e.openUntil) {
+            continue
+        }
+        if e.unhealthy {
+            unhealthy = append(unhealthy, e)
+        } else {
+            healthy = append(healthy, e)
+        }
+    }
+    switch s.balance {
+    case "round-robin":
+        s.next = (s.next + 1) % len(s.endpoints)
+    case "least-connections":
+        sort.SliceStable(healthy, func(i, j int) bool {
+            return healthy[i].active < healthy[j].active
+        })
+    case "primary-backup":
+    default:
+        fmt.Printf("WARNING! Unknown balance policy: %s, using primary-backup\n", s.balance)
+    }
+    return append(healthy, unhealthy...)
+}
+
+// Start a request on e, false if its breaker does not let it through
+func (s *endpointSet) acquire(e *endpoint) (bool) {
+    endpoints.Lock()
+    defer endpoints.Unlock()
+    switch e.breaker {
+    case BREAKER_HALF_OPEN:
+        return false
+    case BREAKER_OPEN:
+        if time.Now().Before(e.openUntil) {
+            return false
+        }
+        fmt.Printf("Circuit breaker of %s half-open, probing\n", e.host)
+        e.breaker = BREAKER_HALF_OPEN
+        e.publish()
+    }
+    e.active++
+    return true
+}
+
+// End a request on e, still counting it as active until release() if keep
+func (s *endpointSet) done(e *endpoint, err error, keep bool) {
+    endpoints.Lock()
+    defer endpoints.Unlock()
+    if keep != true {
+        e.active--
+    }
+    if err == nil || retriable(err) != true {
+        // The endpoint answered
+        e.fails = 0
+        if e.breaker != BREAKER_CLOSED {
+            fmt.Printf("Circuit breaker of %s closed\n", e.host)
+            e.breaker = BREAKER_CLOSED
+            e.publish()
+        }
+        return
+    }
+    s.fail(e, err)
+}
+
+// Must be called locked
+func (s *endpointSet) fail(e *endpoint, err error) {
+    e.fails++
+    fmt.Printf("Upstream %s failed (%d in a row): %s\n", e.host, e.fails, err.Error())
+    metrics.Inc(fmt.Sprintf("ftproxy_upstream_failures_total{endpoint=%q}", e.host))
+    if e.breaker == BREAKER_HALF_OPEN || e.fails >= s.maxFails {
+        if e.breaker != BREAKER_OPEN {
+            fmt.Printf("WARNING! Circuit breaker of %s open for %s\n", e.host, s.failTimeout)
+            metrics.Inc(fmt.Sprintf("ftproxy_breaker_trips_total{endpoint=%q}", e.host))
+        }
+        e.breaker = BREAKER_OPEN
+        e.openUntil = time.Now().Add(s.failTimeout)
+        e.publish()
+    }
+}
+
+func (s *endpointSet) release(e *endpoint) {
+    endpoints.Lock()
+    e.active--
+    endpoints.Unlock()
+}
+
+// A stream broke on e after it was opened
+func (s *endpointSet) broken(e *endpoint, err error) {
+    endpoints.Lock()
+    defer endpoints.Unlock()
+    e.active--
+    s.fail(e, err)
+}
+
+func (s *endpointSet) setHealthy(e *endpoint, healthy bool) {
+    endpoints.Lock()
+    defer endpoints.Unlock()
+    if healthy == (e.unhealthy != true) {
+        return
+    }
+    e.unhealthy = healthy != true
+    if healthy {
+        fmt.Printf("Upstream %s passed its health check\n", e.host)
+    } else {
+        fmt.Printf("WARNING! Upstream %s failed its health check\n", e.host)
+    }
+    e.publish()
+}
+
+// Sleep before retry number attempt (from 1), with full jitter
+func (s *endpointSet) sleep(attempt int) {
+    backoff := s.backoff << uint(attempt - 1)
+    if backoff > s.backoffMax || backoff <= 0 {
+        backoff = s.backoffMax
+    }
+    if backoff > 0 {
+        time.Sleep(time.Duration(rand.Int63n(int64(backoff) + 1)))
+    }
+}
+
+// Reply when every endpoint of the mount has its breaker open
+func (s *endpointSet) unavailable() (error) {
+    endpoints.Lock()
+    defer endpoints.Unlock()
+    var wait time.Duration
+    for i, e := range s.endpoints {
+        if left := time.Until(e.openUntil); i == 0 || left < wait {
+            wait = left
+        }
+    }
+    if wait < 0 {
+        wait = 0
+    }
+    return &ReplyError{Code: 421, Text: fmt.Sprintf("Upstream unavailable, retry in %d seconds.", int(wait.Seconds()) + 1)}
+}
+
+// Only an unavailable upstream is worth another try and counts against its
+// breaker. Missing files, denied access and unknown errors are the same on
+// every mirror.
+func retriable(err error) (bool) {
+    var replyErr *ReplyError
+    if errors.As(err, &replyErr) {
+        // Backends reply 451 for upstream failures they classified
+        return replyErr.Code == 421 || replyErr.Code == 451
+    }
+    var integrityErr *ftpIO.IntegrityError
+    if errors.As(err, &integrityErr) {
+        return false
+    }
+    var urlErr *ftpIO.UrlError
+    if errors.As(err, &urlErr) {
+        if urlErr.StatusCode != 0 {
+            return urlErr.StatusCode >= 500
+        }
+        // Connection closed before any answer
+        if errors.Is(urlErr.Err, io.EOF) || errors.Is(urlErr.Err, io.ErrUnexpectedEOF) {
+            return true
+        }
+    }
+    var netErr net.Error
+    if errors.As(err, &netErr) && netErr.Timeout() || errors.Is(err, context.DeadlineExceeded) {
+        return true
+    }
+    var opErr *net.OpError
+    var dnsErr *net.DNSError
+    return errors.As(err, &opErr) || errors.As(err, &dnsErr)
+}
+
+// Run try on the endpoints of the set until one succeeds, then retry
+// with backoff. skip is left out of the first round.
+func (s *endpointSet) do(skip *endpoint, try func(e *endpoint) (error)) (error) {
+    var err error
+    for attempt := 0; attempt <= s.retries; attempt++ {
+        if attempt > 0 {
+            s.sleep(attempt)
+            metrics.Inc("ftproxy_upstream_retries_total")
+        }
+        tried := false
+        for _, e := range s.order() {
+            if e == skip && attempt == 0 || s.acquire(e) != true {
+                continue
+            }
+            tried = true
+            err = try(e)
+            if err == nil || retriable(err) != true {
+                return err
+            }
+        }
+        if tried != true && (skip == nil || attempt > 0) {
+            return s.unavailable()
+        }
+    }
+    if err == nil {
+        err = errors.New("no other upstream left")
+    }
+    return err
+}
+
+// Backend spreading requests on the endpoints of a mount
+type failoverBackend struct {
+    inner Backend
+}
+
+func withHost(mount cfg.Mount, host string) (cfg.Mount) {
+    mount.Host = host
+    return mount
+}
+
+func (b failoverBackend) List(mount cfg.Mount, dirName string) (FsObjectSlice, error) {
+    return b.ListIf(mount, dirName, &listingValidators{})
+}
+
+func (b failoverBackend) ListIf(mount cfg.Mount, dirName string, validators *listingValidators) (FsObjectSlice, error) {
+    set := getEndpointSet(mount)
+    var objects FsObjectSlice
+    err := set.do(nil, func(e *endpoint) (error) {
+        var err error
+        if lister, ok := b.inner.(conditionalLister); ok {
+            objects, err = lister.ListIf(withHost(mount, e.host), dirName, validators)
+        } else {
+            objects, err = b.inner.List(withHost(mount, e.host), dirName)
+        }
+        if err == errNotModified {
+            set.done(e, nil, false)
+        } else {
+            set.done(e, err, false)
+        }
+        return err
+    })
+    return objects, err
+}
+
+func (b failoverBackend) Open(mount cfg.Mount, filePath string, offset int64) (io.ReadCloser, error) {
+    return b.OpenIf(mount, filePath, offset, &fileVersion{size: -1})
+}
+
+func (b failoverBackend) OpenIf(mount cfg.Mount, filePath string, offset int64, version *fileVersion) (io.ReadCloser, error) {
+    r := &failoverReader{backend: b, mount: mount, set: getEndpointSet(mount), filePath: filePath, offset: offset, version: version}
+    if err := r.open(nil); err != nil {
+        return nil, err
+    }
+    return r, nil
+}
+
+// The version of a file a stream started on
+type fileVersion struct {
+    tag string              // Backend specific: Last-Modified, ETag or MDTM, "" if unknown
+    size int64              // Of the whole file, -1 if unknown
+}
+
+// Backends telling which version of a file they open
+type conditionalOpener interface {
+    // Same as Open(), if the file is still version, else fails with
+    // errFileChanged. An unknown version is set from the file opened.
+    OpenIf(mount cfg.Mount, filePath string, offset int64, version *fileVersion) (io.ReadCloser, error)
+}
+
+var errFileChanged = &ReplyError{Code: 426, Text: "File changed upstream; transfer aborted."}
+
+// Set the version from the file opened, or check it is still the same
+func (v *fileVersion) match(tag string, size int64) (error) {
+    if v.tag == "" {
+        v.tag, v.size = tag, size
+        return nil
+    }
+    if tag != v.tag || v.size >= 0 && size >= 0 && size != v.size {
+        return errFileChanged
+    }
+    return nil
+}
+
+// A RETR stream moving to another endpoint when the current one breaks
+type failoverReader struct {
+    backend failoverBackend
+    mount cfg.Mount
+    set *endpointSet
+    filePath string
+    offset int64                // Of the next byte to read
+    version *fileVersion        // The stream started on
+    current *endpoint
+    reader io.ReadCloser
+}
+
+// Open the stream at r.offset, on another endpoint than broken if possible
+func (r *failoverReader) open(broken *endpoint) (error) {
+    return r.set.do(broken, func(e *endpoint) (error) {
+        var reader io.ReadCloser
+        var err error
+        if opener, ok := r.backend.inner.(conditionalOpener); ok {
+            reader, err = opener.OpenIf(withHost(r.mount, e.host), r.filePath, r.offset, r.version)
+        } else {
+            reader, err = r.backend.inner.Open(withHost(r.mount, e.host), r.filePath, r.offset)
+        }
+        // A changed file is an answer, not an endpoint failure
+        r.set.done(e, err, err == nil)
+        if err == nil {
+            r.current, r.reader = e, reader
+        }
+        return err
+    })
+}
+
+func (r *failoverReader) Read(p []byte) (int, error) {
+    n, err := r.reader.Read(p)
+    r.offset += int64(n)
+    var integrityErr *ftpIO.IntegrityError
+    if err == nil || err == io.EOF || errors.As(err, &integrityErr) {
+        // Bad data is not fixed by reading the end elsewhere
+        return n, err
+    }
+    broken := r.current
+    r.reader.Close()
+    r.set.broken(broken, err)
+    r.current, r.reader = nil, nil
+    var openErr error
+    if r.offset == 0 {
+        // Nothing sent yet, any version will do
+        r.version.tag, r.version.size = "", -1
+        openErr = r.open(broken)
+    } else if r.version.tag == "" {
+        // Nothing tells the file elsewhere is the same, do not splice versions
+        openErr = errors.New("no version to check the file against")
+    } else {
+        openErr = r.open(broken)
+    }
+    if openErr != nil {
+        fmt.Printf("Cannot continue %s at %d: %s\n", r.filePath, r.offset, openErr.Error())
+        if openErr == errFileChanged {
+            err = openErr
+        }
+        r.reader = errReader{err}
+        return n, err
+    }
+    fmt.Printf("Continuing %s at %d on %s\n", r.filePath, r.offset, r.current.host)
+    if n > 0 {
+        return n, nil
+    }
+    return r.Read(p)
+}
+
+func (r *failoverReader) Close() (error) {
+    if r.current == nil {
+        return nil
+    }
+    r.set.release(r.current)
+    r.current = nil
+    return r.reader.Close()
+}
+
+// Fails every read with err, once a stream could not be continued
+type errReader struct {
+    err error
+}
+
+func (e errReader) Read(p []byte) (int, error) {
+    return 0, e.err
+}
+
+func (e errReader) Close() (error) {
+    return nil
+}
+
+// Return the endpoint direct upstream requests of mount should use
+func pickHost(mount cfg.Mount) (string, error) {
+    set := getEndpointSet(mount)
+    ordered := set.order()
+    if len(ordered) == 0 {
+        return "", set.unavailable()
+    }
+    return ordered[0].host, nil
+}
+
+// Poll the health check path of every endpoint of mounts that have one
+func StartHealthChecks() {
+    for _, mount := range cfg.GetMounts() {
+        if mount.HealthCheck == "" {
+            continue
+        }
+        go healthCheckLoop(mount)
+    }
+}
+
+func healthCheckLoop(mount cfg.Mount) {
+    set := getEndpointSet(mount)
+    for {
+        for _, e := range set.endpoints {
+            set.setHealthy(e, healthCheck(mount, e.host))
+        }
+        time.Sleep(seconds(mount.HealthInterval))
+    }
+}
+
+func healthCheck(mount cfg.Mount, host string) (bool) {
+    var url string
+    switch mount.Scheme {
+    case "http", "s3+http", "dav":
+        url = "http://" + host + mount.HealthCheck
+    case "s3", "davs":
+        url = "https://" + host + mount.HealthCheck
+    default:
+        // No HTTP, check the port answers
+        if strings.Contains(host, ":") != true {
+            host += ":21"
+        }
+        conn, err := net.DialTimeout("tcp", host, 10 * time.Second)
+        if err != nil {
+            return false
+        }
+        conn.Close()
+        return true
+    }
+    resp, err := ftpIO.HostClient(host).Get(url)
+    if err != nil {
+        return false
+    }
+    ftpIO.CloseUrl(resp)
+    return resp.StatusCode < http.StatusBadRequest
+}
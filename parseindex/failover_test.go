@@ -0,0 +1,274 @@
+package parseindex
+
+import "bytes"
+import "context"
+import "errors"
+import "fmt"
+import "ftpIO"
+import "io"
+import "net"
+import "net/http"
+import "strings"
+import "testing"
+import "time"
+
+func TestRetriable(t *testing.T) {
+    tests := []struct {
+        err error
+        want bool
+    }{
+        {&ReplyError{Code: 421, Text: "Upstream unavailable"}, true},
+        {&ReplyError{Code: 451, Text: "Upstream error"}, true},
+        {&ReplyError{Code: 550, Text: "No such file"}, false},
+        {errFileChanged, false},
+        {&ftpIO.IntegrityError{Url: "http://a/f", Reason: "md5 checksum mismatch"}, false},
+        {&ftpIO.UrlError{Url: "http://a/f", StatusCode: 503}, true},
+        {&ftpIO.UrlError{Url: "http://a/f", StatusCode: 500}, true},
+        {&ftpIO.UrlError{Url: "http://a/f", StatusCode: 404}, false},
+        {&ftpIO.UrlError{Url: "http://a/f", StatusCode: 403}, false},
+        {&ftpIO.UrlError{Url: "http://a/f", Err: io.EOF}, true},
+        {&ftpIO.UrlError{Url: "http://a/f", Err: io.ErrUnexpectedEOF}, true},
+        {&ftpIO.UrlError{Url: "http://a/f", Err: ftpIO.ErrChanged}, false},
+        {&ftpIO.UrlError{Url: "http://a/f", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, true},
+        {&net.DNSError{Err: "no such host", Name: "a"}, true},
+        {fmt.Errorf("segment at 0: %w", context.DeadlineExceeded), true},
+        {errors.New("unknown"), false},
+    }
+    for _, test := range tests {
+        if got := retriable(test.err); got != test.want {
+            t.Errorf("retriable(%v) = %v, want %v", test.err, got, test.want)
+        }
+    }
+}
+
+var errUnavailable = &ftpIO.UrlError{Url: "http://upstream.test/f", StatusCode: 503}
+var errNotFound = &ftpIO.UrlError{Url: "http://upstream.test/f", StatusCode: 404}
+
+func newTestSet(hosts ...string) (*endpointSet) {
+    s := &endpointSet{balance: "primary-backup", maxFails: 2, failTimeout: 50 * time.Millisecond, backoffMax: time.Millisecond}
+    for _, host := range hosts {
+        s.endpoints = append(s.endpoints, &endpoint{host: host})
+    }
+    return s
+}
+
+func TestBreaker(t *testing.T) {
+    s := newTestSet("breaker-a.test", "breaker-b.test")
+    a, b := s.endpoints[0], s.endpoints[1]
+    for i := 0; i < 2; i++ {
+        if s.acquire(a) != true {
+            t.Fatalf("closed breaker refused request %d", i)
+        }
+        s.done(a, errUnavailable, false)
+    }
+    if a.breaker != BREAKER_OPEN || a.fails != 2 {
+        t.Fatalf("after 2 failures: breaker %d, %d failures", a.breaker, a.fails)
+    }
+    if order := s.order(); len(order) != 1 || order[0] != b {
+        t.Errorf("open endpoint still ordered")
+    }
+    if s.acquire(a) {
+        t.Errorf("open breaker let a request through")
+    }
+
+    // A missing file is an answer
+    s.acquire(b)
+    s.done(b, errNotFound, false)
+    if b.fails != 0 || b.breaker != BREAKER_CLOSED {
+        t.Errorf("404 counted as a failure")
+    }
+
+    // Half-open after failTimeout, a single probe
+    time.Sleep(60 * time.Millisecond)
+    if order := s.order(); len(order) != 2 || order[0] != a {
+        t.Errorf("endpoint not back after failTimeout")
+    }
+    if s.acquire(a) != true || a.breaker != BREAKER_HALF_OPEN {
+        t.Fatalf("no probe after failTimeout, breaker %d", a.breaker)
+    }
+    if s.acquire(a) || len(s.order()) != 1 {
+        t.Errorf("half-open breaker let a second request through")
+    }
+    // A failed probe opens it again at once
+    s.done(a, errUnavailable, false)
+    if a.breaker != BREAKER_OPEN {
+        t.Errorf("failed probe left breaker %d", a.breaker)
+    }
+    time.Sleep(60 * time.Millisecond)
+    s.acquire(a)
+    s.done(a, nil, false)
+    if a.breaker != BREAKER_CLOSED || a.fails != 0 || a.active != 0 {
+        t.Errorf("after a good probe: breaker %d, %d failures, %d active", a.breaker, a.fails, a.active)
+    }
+
+    // All open: 421 with the time left
+    for _, e := range s.endpoints {
+        e.breaker, e.openUntil = BREAKER_OPEN, time.Now().Add(time.Minute)
+    }
+    err := s.do(nil, func(e *endpoint) (error) {
+        t.Errorf("request sent to open endpoint %s", e.host)
+        return nil
+    })
+    var replyErr *ReplyError
+    if errors.As(err, &replyErr) != true || replyErr.Code != 421 {
+        t.Errorf("all breakers open gives %v", err)
+    }
+}
+
+func TestDoRetries(t *testing.T) {
+    s := newTestSet("retry-a.test", "retry-b.test")
+    s.maxFails = 100
+    s.retries = 2
+    s.backoff = time.Millisecond
+    var tried []string
+    try := func(err error) (func(e *endpoint) (error)) {
+        return func(e *endpoint) (error) {
+            tried = append(tried, strings.TrimSuffix(e.host, ".test"))
+            s.done(e, err, false)
+            return err
+        }
+    }
+    if err := s.do(nil, try(errUnavailable)); err != errUnavailable {
+        t.Errorf("do gives %v", err)
+    }
+    if strings.Join(tried, ",") != "retry-a,retry-b,retry-a,retry-b,retry-a,retry-b" {
+        t.Errorf("tried %v", tried)
+    }
+
+    // Answers are not retried
+    tried = nil
+    if err := s.do(nil, try(errNotFound)); err != errNotFound || len(tried) != 1 {
+        t.Errorf("404 gives %v after %v", err, tried)
+    }
+
+    // The broken endpoint is left out of the first round only
+    tried = nil
+    s.do(s.endpoints[0], try(errUnavailable))
+    if strings.Join(tried, ",") != "retry-b,retry-a,retry-b,retry-a,retry-b" {
+        t.Errorf("tried %v skipping retry-a", tried)
+    }
+}
+
+func TestBackoff(t *testing.T) {
+    s := newTestSet()
+    s.backoff = time.Millisecond
+    s.backoffMax = 5 * time.Millisecond
+    start := time.Now()
+    // Shifts past the maximum, and past int64
+    for _, attempt := range []int{1, 3, 10, 70} {
+        s.sleep(attempt)
+    }
+    if elapsed := time.Since(start); elapsed > 200 * time.Millisecond {
+        t.Errorf("backoff took %s, capped at %s per retry", elapsed, s.backoffMax)
+    }
+}
+
+func TestFailoverResume(t *testing.T) {
+    data := testData(1000)
+    a := newTestMirror(t, data, "\"a\"")
+    a.dropAfter = 300
+    b := newTestMirror(t, data, "\"b\"")
+    mount := testMirrorMount("/fo-resume", a, b)
+    mount.Balance = "primary-backup"
+    mount.MaxFails = 1
+    mount.FailTimeout = 0.05
+    r, err := failoverBackend{inner: httpBackend{}}.Open(mount, "/fo-resume/file", 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    got, err := io.ReadAll(r)
+    r.Close()
+    if err != nil || bytes.Equal(got, data) != true {
+        t.Fatalf("read %d bytes, error %v", len(got), err)
+    }
+    b.Lock()
+    if b.lastRange != "bytes=300-" || b.lastIfRange != a.modified.Format(http.TimeFormat) {
+        t.Errorf("continued with Range %q If-Range %q", b.lastRange, b.lastIfRange)
+    }
+    b.Unlock()
+    set := getEndpointSet(mount)
+    if set.endpoints[0].fails != 1 || set.endpoints[1].fails != 0 {
+        t.Errorf("failures: %d and %d", set.endpoints[0].fails, set.endpoints[1].fails)
+    }
+    for _, e := range set.endpoints {
+        if e.active != 0 {
+            t.Errorf("%s still has %d active", e.host, e.active)
+        }
+    }
+    if set.endpoints[0].breaker != BREAKER_OPEN {
+        t.Fatalf("broken stream left breaker %d", set.endpoints[0].breaker)
+    }
+
+    // Skipped while open, probed once failTimeout is over
+    a.Lock()
+    a.dropAfter, a.gets = 0, 0
+    a.Unlock()
+    read := func() {
+        r, err := failoverBackend{inner: httpBackend{}}.Open(mount, "/fo-resume/file", 0)
+        if err != nil {
+            t.Fatal(err)
+        }
+        got, err := io.ReadAll(r)
+        r.Close()
+        if err != nil || bytes.Equal(got, data) != true {
+            t.Fatalf("read %d bytes, error %v", len(got), err)
+        }
+    }
+    read()
+    a.Lock()
+    if a.gets != 0 {
+        t.Errorf("open endpoint got %d requests", a.gets)
+    }
+    a.Unlock()
+    time.Sleep(60 * time.Millisecond)
+    read()
+    a.Lock()
+    if a.gets != 1 {
+        t.Errorf("half-open endpoint got %d requests", a.gets)
+    }
+    a.Unlock()
+    if set.endpoints[0].breaker != BREAKER_CLOSED {
+        t.Errorf("good probe left breaker %d", set.endpoints[0].breaker)
+    }
+}
+
+func TestFailoverResumeChanged(t *testing.T) {
+    data := testData(1000)
+    a := newTestMirror(t, data, "\"a\"")
+    a.dropAfter = 300
+    // Out of sync mirror, same size
+    b := newTestMirror(t, bytes.Repeat([]byte("x"), 1000), "\"b\"")
+    b.modified = b.modified.Add(time.Hour)
+    mount := testMirrorMount("/fo-changed", a, b)
+    mount.Balance = "primary-backup"
+    r, err := failoverBackend{inner: httpBackend{}}.Open(mount, "/fo-changed/file", 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    got, err := io.ReadAll(r)
+    r.Close()
+    if errors.Is(err, errFileChanged) != true || bytes.Equal(got, data[:300]) != true {
+        t.Errorf("read %d bytes, error %v", len(got), err)
+    }
+    if code, _ := ErrorReply(&ftpIO.TransferError{Upstream: true, Err: err}, 451, ""); code != 426 {
+        t.Errorf("changed file replied %d", code)
+    }
+    // Not the mirror's fault
+    if e := getEndpointSet(mount).endpoints[1]; e.fails != 0 {
+        t.Errorf("changed file counted as %d failures", e.fails)
+    }
+
+    // Same Last-Modified, but another size
+    c := newTestMirror(t, data[:999], "\"c\"")
+    mount = testMirrorMount("/fo-resized", a, c)
+    mount.Balance = "primary-backup"
+    r, err = failoverBackend{inner: httpBackend{}}.Open(mount, "/fo-resized/file", 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = io.ReadAll(r)
+    r.Close()
+    if errors.Is(err, errFileChanged) != true {
+        t.Errorf("resized file gives %v", err)
+    }
+}
@@ -0,0 +1,76 @@
+package parseindex
+
+import "bufio"
+import "cfg"
+import "fmt"
+import "ftpclient"
+import "net"
+import "strings"
+import "sync/atomic"
+import "testing"
+
+// An FTP server only able to log in, counting control connections
+func newLoginServer(t *testing.T) (string, *int32) {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { listener.Close() })
+    var accepted int32
+    go func() {
+        for {
+            conn, err := listener.Accept()
+            if err != nil {
+                return
+            }
+            atomic.AddInt32(&accepted, 1)
+            go func(conn net.Conn) {
+                defer conn.Close()
+                fmt.Fprint(conn, "220 Ready\r\n")
+                lines := bufio.NewScanner(conn)
+                for lines.Scan() {
+                    switch strings.Fields(lines.Text() + " ")[0] {
+                    case "USER":
+                        fmt.Fprint(conn, "230 Logged in\r\n")
+                    case "TYPE", "NOOP":
+                        fmt.Fprint(conn, "200 OK\r\n")
+                    default:
+                        fmt.Fprint(conn, "502 Not implemented\r\n")
+                    }
+                }
+            }(conn)
+        }
+    }()
+    return listener.Addr().String(), &accepted
+}
+
+func TestFtpRelease(t *testing.T) {
+    addr, accepted := newLoginServer(t)
+    mount := cfg.Mount{Host: addr, User: "test"}
+    tests := []struct {
+        err error
+        reused bool
+    }{
+        {nil, true},
+        {&ftpclient.Error{Code: 550, Msg: "No such file"}, true},
+        {&ftpclient.Error{Code: 450, Msg: "Busy"}, true},
+        {&ftpclient.Error{Code: 421, Msg: "Closing control connection"}, false},
+        {&net.OpError{Op: "read", Err: fmt.Errorf("connection reset")}, false},
+    }
+    for _, test := range tests {
+        c, err := ftpclient.Get(addr, mount.User, "")
+        if err != nil {
+            t.Fatal(err)
+        }
+        ftpRelease(mount, c, test.err)
+        before := atomic.LoadInt32(accepted)
+        c, err = ftpclient.Get(addr, mount.User, "")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if reused := atomic.LoadInt32(accepted) == before; reused != test.reused {
+            t.Errorf("after %v: connection reused %v", test.err, reused)
+        }
+        c.Close()
+    }
+}
@@ -0,0 +1,249 @@
+package parseindex
+
+// Fallback for unknown index pages. Entries are the links to direct
+// children of the page on the same origin, without query. Their date and
+// size are guessed from the text around the link, up to the nearest
+// block element or line break. The mount option "headEntries" gets them
+// exactly instead.
+
+import "golang.org/x/net/html"
+import "io"
+import "math"
+import "net/url"
+import "regexp"
+import "strconv"
+import "strings"
+import "time"
+
+type dateLayout struct {
+    re *regexp.Regexp
+    formats []string
+}
+
+// Tried in order on the text around a link
+var genericDateLayouts = []dateLayout{
+    {
+        regexp.MustCompile("[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}(\\.[0-9]+)?(Z|[+-][0-9]{2}:[0-9]{2})"),
+        []string{time.RFC3339Nano},
+    },
+    {
+        regexp.MustCompile("[0-9]{4}-[0-9]{2}-[0-9]{2}[ T][0-9]{2}:[0-9]{2}(:[0-9]{2})?"),
+        []string{"2006-01-02 15:04", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02T15:04:05"},
+    },
+    {
+        regexp.MustCompile("[0-9]{1,2}-[A-Za-z]{3}-[0-9]{4} [0-9]{2}:[0-9]{2}(:[0-9]{2})?"),
+        []string{"_2-Jan-2006 15:04", "_2-Jan-2006 15:04:05"},
+    },
+    {
+        regexp.MustCompile("[0-9]{4}-[A-Za-z]{3}-[0-9]{2} [0-9]{2}:[0-9]{2}:[0-9]{2}"),
+        []string{"2006-Jan-02 15:04:05"},
+    },
+    {
+        regexp.MustCompile("[A-Za-z]{3} [0-9]{1,2},? [0-9]{4}( [0-9]{2}:[0-9]{2})?"),
+        []string{"Jan 2 2006", "Jan 2, 2006", "Jan 2 2006 15:04", "Jan 2, 2006 15:04"},
+    },
+    {
+        regexp.MustCompile("[0-9]{4}-[0-9]{2}-[0-9]{2}"),
+        []string{"2006-01-02"},
+    },
+}
+
+// A number with an optional unit: "1,234", "1.2K", "4.0 MiB", "17 bytes"
+var genericSizeRe = regexp.MustCompile("(?i)(?:^|[^0-9A-Za-z.,])([0-9][0-9,]*(?:\\.[0-9]+)?) ?([KMGTPE](?:i?B)?|B|bytes)?(?:$|[^0-9A-Za-z])")
+
+// Elements around entries, their text is not shared
+var genericBlocks = map[string]bool{
+    "br": true, "dd": true, "div": true, "dt": true, "h1": true, "h2": true, "h3": true,
+    "hr": true, "li": true, "ol": true, "p": true, "pre": true, "table": true, "tbody": true,
+    "tr": true, "ul": true,
+}
+
+// Return the object of a link to a direct child of the page, false for
+// sort, parent, external and other links
+func genericEntry(pageUrl *url.URL, href string) (FsObject, bool) {
+    u, err := url.Parse(href)
+    if err != nil {
+        return FsObject{}, false
+    }
+    u = pageUrl.ResolveReference(u)
+    dirPath := pageUrl.Path
+    if strings.HasSuffix(dirPath, "/") != true {
+        dirPath += "/"
+    }
+    if u.Scheme != pageUrl.Scheme || u.Host != pageUrl.Host || u.RawQuery != "" || strings.HasPrefix(u.Path, dirPath) != true {
+        return FsObject{}, false
+    }
+    object := FsObject{otype: FS_FILE, size: -1, time: time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)}
+    name := strings.TrimPrefix(u.Path, dirPath)
+    if strings.HasSuffix(name, "/") {
+        object.otype = FS_DIR
+        object.size = 4096 /* XXX fake size */
+        name = strings.TrimSuffix(name, "/")
+    }
+    if name == "" || strings.Contains(name, "/") {
+        return FsObject{}, false
+    }
+    object.name = name
+    return object, true
+}
+
+// Return the first date of text, and the text without it, the part
+// after the date first
+func findDate(text string) (time.Time, string, bool) {
+    for _, layout := range genericDateLayouts {
+        match := layout.re.FindStringIndex(text)
+        if match == nil {
+            continue
+        }
+        for _, format := range layout.formats {
+            if tim, err := time.Parse(format, text[match[0]:match[1]]); err == nil {
+                return tim.UTC(), text[match[1]:] + " " + text[:match[0]], true
+            }
+        }
+    }
+    return time.Time{}, text, false
+}
+
+// Return the first size of text. Units are powers of 1024, except
+// "kB", "MB" etc. which are powers of 1000.
+func findSize(text string) (int64, bool) {
+    match := genericSizeRe.FindStringSubmatch(text)
+    if match == nil {
+        return 0, false
+    }
+    size, err := strconv.ParseFloat(strings.ReplaceAll(match[1], ",", ""), 64)
+    if err != nil {
+        return 0, false
+    }
+    unit := strings.ToUpper(match[2])
+    if unit != "" && unit != "B" && unit != "BYTES" {
+        power := float64(strings.Index(SIZE_SUFFIXES, unit[:1]) + 1)
+        if strings.HasSuffix(unit, "B") && strings.HasSuffix(unit, "IB") != true {
+            size *= math.Pow(1000, power)
+        } else {
+            size *= math.Pow(1024, power)
+        }
+    }
+    return int64(size), true
+}
+
+// Set the date and size of object from the text after its link, or
+// before it if there is no date after it
+func genericFields(object *FsObject, after string, before string) {
+    after = strings.Join(strings.Fields(after), " ")
+    before = strings.Join(strings.Fields(before), " ")
+    tim, rest, ok := findDate(after)
+    if ok != true {
+        tim, rest, ok = findDate(before)
+        rest = after + " " + rest
+    }
+    if ok {
+        object.time = tim
+    } else {
+        rest = after
+    }
+    if object.otype != FS_FILE {
+        return
+    }
+    if size, ok := findSize(rest); ok {
+        object.size = size
+    }
+}
+
+func ParseGenericHtmlList(r io.Reader, pageUrl *url.URL) ([]FsObject) {
+    z := html.NewTokenizer(r)
+
+    var objects []FsObject
+    seen := make(map[string]bool)
+    var curObj *FsObject
+    var before string           // Text of the block before the current entry
+    var curBefore string        // Same, for the current entry
+    var after string            // Text following the current entry link
+    var inLink bool
+    var inPre bool
+    var skip bool               // In a script or style
+
+    finish := func() {
+        if curObj != nil {
+            genericFields(curObj, after, curBefore)
+            objects = append(objects, *curObj)
+        }
+        curObj = nil
+        after = ""
+        before = ""
+    }
+
+    for {
+    tt := z.Next()
+
+    switch tt {
+    case html.ErrorToken:
+        // End of the document, we're done
+        finish()
+        return objects
+    case html.StartTagToken, html.SelfClosingTagToken:
+        t := z.Token()
+        if genericBlocks[t.Data] {
+            finish()
+        }
+        switch t.Data {
+        case "a":
+            inLink = true
+            object, ok := genericEntry(pageUrl, getTokenAttr(&t, "href"))
+            // Icon and name links of the same entry
+            if ok != true || (curObj != nil && curObj.name == object.name) {
+                break
+            }
+            if seen[object.name] {
+                // Duplicate links elsewhere in the page
+                break
+            }
+            if curObj != nil {
+                finish()
+            }
+            seen[object.name] = true
+            curBefore = before
+            before = ""
+            curObj = &object
+        case "pre":
+            inPre = true
+        case "script", "style":
+            skip = true
+        }
+    case html.EndTagToken:
+        t := z.Token()
+        if genericBlocks[t.Data] {
+            finish()
+        }
+        switch t.Data {
+        case "a":
+            inLink = false
+        case "pre":
+            inPre = false
+        case "script", "style":
+            skip = false
+        }
+    case html.TextToken:
+        if skip || inLink {
+            break
+        }
+        text := string(z.Text())
+        if inPre {
+            // A line ends an entry of a preformatted listing
+            lines := strings.Split(text, "\n")
+            for _, line := range lines[:len(lines) - 1] {
+                if curObj != nil {
+                    after += line
+                }
+                finish()
+            }
+            text = lines[len(lines) - 1]
+        }
+        if curObj != nil {
+            after += " " + text
+        } else {
+            before += " " + text
+        }
+    }
+    }
+}
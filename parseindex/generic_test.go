@@ -0,0 +1,62 @@
+package parseindex
+
+import "net/url"
+import "strings"
+import "testing"
+
+func TestParseGenericHtmlList(t *testing.T) {
+    // Script, external, parent, query, fragment and nested links are left out
+    pageUrl, _ := url.Parse("http://127.0.0.1:9012/custom/")
+    got := ParseGenericHtmlList(strings.NewReader(readTestdata(t, "generic.html")), pageUrl)
+    checkObjects(t, "generic.html", got, []FsObject{
+        {otype: FS_FILE, name: "release-1.0.tar.gz", size: 13107200, time: testTime("2024-03-04T05:06:00Z")},
+        {otype: FS_DIR, name: "docs", size: 4096, time: testTime("2024-03-05T00:00:00Z")},
+        {otype: FS_FILE, name: "abs.bin", size: 1234, time: testTime("2023-12-31T23:59:59Z")},
+        {otype: FS_FILE, name: "notes v2.txt", size: 3000, time: testTime("1970-01-01T00:00:00Z")},
+    })
+}
+
+func TestFindSize(t *testing.T) {
+    tests := []struct {
+        text string
+        size int64
+        ok bool
+    }{
+        {"17", 17, true},
+        {"1,234 bytes", 1234, true},
+        {"1.5K", 1536, true},
+        {"4.0 MiB", 4194304, true},
+        {"3 kB", 3000, true},
+        {"2 MB", 2000000, true},
+        {"-", 0, false},
+        {"v2", 0, false},
+    }
+    for _, test := range tests {
+        size, ok := findSize(test.text)
+        if size != test.size || ok != test.ok {
+            t.Errorf("findSize(%q) = %d, %v, want %d, %v", test.text, size, ok, test.size, test.ok)
+        }
+    }
+}
+
+func TestFindDate(t *testing.T) {
+    tests := []struct {
+        text string
+        want string
+        rest string
+    }{
+        {"2024-03-04 05:06 12K", "2024-03-04T05:06:00Z", " 12K "},
+        {"01-Jan-2024 10:00 -", "2024-01-01T10:00:00Z", " - "},
+        {"size 5, Mar 5, 2024", "2024-03-05T00:00:00Z", " size 5, "},
+    }
+    for _, test := range tests {
+        tim, rest, ok := findDate(test.text)
+        if ok != true || tim.Equal(testTime(test.want)) != true || rest != test.rest {
+            t.Errorf("findDate(%q) = %s, %q, %v, want %s, %q", test.text, tim, rest, ok, test.want, test.rest)
+        }
+    }
+    // A year is not a size once the date is taken out
+    if _, rest, _ := findDate("2023-12-31"); strings.TrimSpace(rest) != "" {
+        t.Errorf("findDate left %q", rest)
+    }
+}
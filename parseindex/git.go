@@ -0,0 +1,115 @@
+package parseindex
+
+import "errors"
+import "fmt"
+import "cfg"
+import "gitrepo"
+import "io"
+import "strings"
+
+// Backend for local bare git repositories, mounted from "git:///srv/repo.git".
+// Top-level directories are branches and tags, each containing the tree of its commit.
+type gitBackend struct{}
+
+var errGitNotFound = &ReplyError{Code: 550, Text: "No such file or directory."}
+
+// Split relPath into the longest matching ref name and the path within its tree
+func gitMatchRef(refs []gitrepo.Ref, relPath string) (gitrepo.Ref, string, bool) {
+    var match gitrepo.Ref
+    var found bool
+    for _, ref := range refs {
+        if relPath == ref.Name || strings.HasPrefix(relPath, ref.Name + "/") {
+            if found != true || len(ref.Name) > len(match.Name) {
+                match = ref
+                found = true
+            }
+        }
+    }
+    if found != true {
+        return match, "", false
+    }
+    return match, strings.Trim(strings.TrimPrefix(relPath, match.Name), "/"), true
+}
+
+func (gitBackend) List(mount cfg.Mount, dirName string) (FsObjectSlice, error) {
+    var objects FsObjectSlice
+    repo := &gitrepo.Repo{GitDir: mount.Path}
+    refs, err := repo.Refs()
+    if err != nil {
+        fmt.Println("Cannot read git refs:", err.Error())
+        return objects, err
+    }
+    relPath := mountRelPath(mount, dirName)
+    curObj := new(FsObject)
+
+    ref, treePath, ret := gitMatchRef(refs, relPath)
+    if ret == true {
+        entries, err := repo.List(ref.RefName, treePath)
+        if err != nil {
+            if errors.Is(err, gitrepo.ErrNotFound) {
+                return objects, errGitNotFound
+            }
+            return objects, err
+        }
+        for _, entry := range entries {
+            curObj.name = entry.Name
+            curObj.time = ref.Time
+            if entry.IsDir {
+                curObj.otype = FS_DIR
+                curObj.size = 4096 /* XXX fake size */
+            } else {
+                curObj.otype = FS_FILE
+                curObj.size = entry.Size
+            }
+            objects = append(objects, *curObj)
+        }
+        return objects, nil
+    }
+
+    // Ref names containing slashes, e.g. "release/1.0", appear as nested directories
+    var refPrefix string
+    if relPath != "" {
+        refPrefix = relPath + "/"
+    }
+    seen := make(map[string]bool)
+    for _, ref := range refs {
+        if !strings.HasPrefix(ref.Name, refPrefix) {
+            continue
+        }
+        name := strings.SplitN(strings.TrimPrefix(ref.Name, refPrefix), "/", 2)[0]
+        if seen[name] {
+            continue
+        }
+        seen[name] = true
+        curObj.name = name
+        curObj.time = ref.Time
+        curObj.size = 4096 /* XXX fake size */
+        curObj.otype = FS_DIR
+        objects = append(objects, *curObj)
+    }
+    if len(objects) == 0 && relPath != "" {
+        return objects, errGitNotFound
+    }
+    return objects, nil
+}
+
+func (gitBackend) Open(mount cfg.Mount, filePath string, offset int64) (io.ReadCloser, error) {
+    repo := &gitrepo.Repo{GitDir: mount.Path}
+    refs, err := repo.Refs()
+    if err != nil {
+        fmt.Println("Cannot read git refs:", err.Error())
+        return nil, err
+    }
+    ref, blobPath, ret := gitMatchRef(refs, mountRelPath(mount, filePath))
+    if ret != true || blobPath == "" {
+        return nil, errGitNotFound
+    }
+    blob, err := repo.Open(ref.RefName, blobPath, offset)
+    if err != nil {
+        if errors.Is(err, gitrepo.ErrNotFound) {
+            return nil, errGitNotFound
+        }
+        return nil, err
+    }
+    return blob, nil
+}
@@ -0,0 +1,139 @@
+package parseindex
+
+import "errors"
+import "cfg"
+import "ftpIO"
+import "io"
+import "net/http"
+import "path"
+import "strings"
+import "sync"
+
+// Requests in flight for the entries of a listing, for redirects or HEADs
+const ENTRY_REQUESTS = 8
+
+// Backend for HTTP vhosts serving autoindex pages
+type httpBackend struct{}
+
+func (b httpBackend) List(mount cfg.Mount, dirName string) (FsObjectSlice, error) {
+    return b.ListIf(mount, dirName, &listingValidators{})
+}
+
+// Returns errNotModified if the index page still matches validators
+func (httpBackend) ListIf(mount cfg.Mount, dirName string, validators *listingValidators) (FsObjectSlice, error) {
+    var objects FsObjectSlice
+    var resp *http.Response
+    // Index pages are at "dir/", "dir" would only redirect there
+    indexPath := strings.TrimSuffix(dirName, "/") + "/"
+    if mount.IndexQuery != "" {
+        indexPath += "?" + mount.IndexQuery
+    }
+    // Some formats must be asked for, e.g. Caddy json
+    var accept string
+    if parser := getParser(mount.Parser); parser != nil {
+        accept = parser.accept
+    }
+    err := ftpIO.OpenUrlIf(mount.Host, indexPath, accept, validators.etag, validators.lastModified, &resp)
+    if err != nil {
+        return objects, err
+    }
+    if resp.StatusCode == http.StatusNotModified {
+        return objects, errNotModified
+    }
+    validators.etag = resp.Header.Get("ETag")
+    validators.lastModified = resp.Header.Get("Last-Modified")
+    objects = parseIndex(mount, resp)
+    ftpIO.CloseUrl(resp)
+    if mount.HeadEntries {
+        headEntries(mount, dirName, objects)
+    }
+    if mount.RedirectLinks {
+        findRedirectLinks(mount, dirName, objects)
+    }
+    return objects, nil
+}
+
+// Call fn on the upstream objects, with at most ENTRY_REQUESTS at a time
+func forEachEntry(objects FsObjectSlice, fn func(object *FsObject)) {
+    var wg sync.WaitGroup
+    slots := make(chan struct{}, ENTRY_REQUESTS)
+    for i := range objects {
+        if objects[i].virtual {
+            continue
+        }
+        wg.Add(1)
+        slots <- struct{}{}
+        go func(object *FsObject) {
+            defer wg.Done()
+            defer func() { <-slots }()
+            fn(object)
+        }(&objects[i])
+    }
+    wg.Wait()
+}
+
+// Set the link of the objects redirecting within the mount host. The
+// target is relative if in the same directory, else a full FTP path.
+func findRedirectLinks(mount cfg.Mount, dirName string, objects FsObjectSlice) {
+    dirName = path.Clean(dirName)
+    forEachEntry(objects, func(object *FsObject) {
+        filePath := path.Join(dirName, object.name)
+        if object.otype == FS_DIR {
+            filePath += "/"
+        }
+        target, err := ftpIO.RedirectTarget(mount.Host, filePath)
+        if err != nil || target == nil || target.Host != mount.Host {
+            return
+        }
+        targetPath := path.Clean(target.Path)
+        if targetPath == path.Join(dirName, object.name) || strings.HasPrefix(targetPath + "/", mount.Prefix + "/") != true {
+            // Trailing slash redirect, or out of the mount
+            return
+        }
+        if path.Dir(targetPath) == dirName {
+            object.link = path.Base(targetPath)
+        } else {
+            object.link = targetPath
+        }
+    })
+}
+
+// Set the exact size and time of the files from HEAD requests
+func headEntries(mount cfg.Mount, dirName string, objects FsObjectSlice) {
+    forEachEntry(objects, func(object *FsObject) {
+        if object.otype != FS_FILE {
+            return
+        }
+        var resp *http.Response
+        if err := ftpIO.HeadUrl(mount.Host, path.Join(dirName, object.name), &resp); err != nil {
+            return
+        }
+        if resp.ContentLength >= 0 {
+            object.size = resp.ContentLength
+        }
+        if tim, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+            object.time = tim
+        }
+    })
+}
+
+func (b httpBackend) Open(mount cfg.Mount, filePath string, offset int64) (io.ReadCloser, error) {
+    return b.OpenIf(mount, filePath, offset, &fileVersion{size: -1})
+}
+
+// The version is the If-Range validator, a changed file is sent whole
+func (httpBackend) OpenIf(mount cfg.Mount, filePath string, offset int64, version *fileVersion) (io.ReadCloser, error) {
+    var resp *http.Response
+    err := ftpIO.OpenUrlFromIf(mount.Host, filePath, offset, version.tag, &resp)
+    if errors.Is(err, ftpIO.ErrChanged) {
+        return nil, errFileChanged
+    }
+    if err != nil {
+        return nil, err
+    }
+    if err = version.match(ftpIO.IfRangeValidator(resp.Header), ftpIO.FileSize(resp)); err != nil {
+        ftpIO.CloseUrl(resp)
+        return nil, err
+    }
+    return resp.Body, nil
+}
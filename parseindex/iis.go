@@ -0,0 +1,90 @@
+package parseindex
+
+// IIS directory browsing: a <pre> with one entry per <br>, its date, time
+// and "<dir>" or size before an absolute link:
+//  1/2/2024 11:00 AM        <dir> <A HREF="/pub/sub/">sub</A><br>
+
+import "golang.org/x/net/html"
+import "io"
+import "net/url"
+import "path"
+import "regexp"
+import "strconv"
+import "strings"
+import "time"
+
+var iisDateRe = regexp.MustCompile("[0-9]{1,2}/[0-9]{1,2}/[0-9]{4} [0-9]{1,2}:[0-9]{2}( [AP]M)?|[A-Za-z]+, [A-Za-z]+ [0-9]{1,2}, [0-9]{4} [0-9]{1,2}:[0-9]{2}( [AP]M)?")
+
+var iisDateFormats = []string{
+    "1/2/2006 3:04 PM",
+    "1/2/2006 15:04",
+    "Monday, January 2, 2006 3:04 PM",
+    "Monday, January 2, 2006 15:04",
+}
+
+// Return the object of an entry from the text before its link, false
+// if there is no date, as for the parent link
+func iisEntry(href string, fields string) (FsObject, bool) {
+    fields = strings.Join(strings.Fields(fields), " ")
+    match := iisDateRe.FindStringIndex(fields)
+    u, err := url.Parse(href)
+    if match == nil || err != nil {
+        return FsObject{}, false
+    }
+    object := FsObject{otype: FS_FILE, size: -1, name: path.Base(u.Path)}
+    for _, format := range iisDateFormats {
+        if tim, err := time.Parse(format, fields[match[0]:match[1]]); err == nil {
+            object.time = tim
+            break
+        }
+    }
+    sizeText := strings.TrimSpace(fields[match[1]:])
+    if sizeText == "<dir>" || strings.HasSuffix(u.Path, "/") {
+        object.otype = FS_DIR
+        object.size = 4096 /* XXX fake size */
+    } else if size, err := strconv.ParseInt(sizeText, 10, 64); err == nil {
+        object.size = size
+    }
+    return object, object.name != "/" && object.name != "."
+}
+
+func ParseIisHtmlList(r io.Reader) ([]FsObject) {
+    z := html.NewTokenizer(r)
+
+    var objects []FsObject
+    var fields string           // Text since the last entry
+    var inPre bool
+
+    for {
+    tt := z.Next()
+
+    switch tt {
+    case html.ErrorToken:
+        // End of the document, we're done
+        return objects
+    case html.StartTagToken, html.SelfClosingTagToken:
+        t := z.Token()
+        switch t.Data {
+        case "pre":
+            inPre = true
+        case "br":
+            fields = ""
+        case "a":
+            if inPre != true {
+                break
+            }
+            if object, ok := iisEntry(getTokenAttr(&t, "href"), fields); ok {
+                objects = append(objects, object)
+            }
+            fields = ""
+        }
+    case html.EndTagToken:
+        t := z.Token()
+        if t.Data == "pre" {
+            inPre = false
+        }
+    case html.TextToken:
+        fields += string(z.Text())
+    }
+    }
+}
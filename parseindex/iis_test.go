@@ -0,0 +1,13 @@
+package parseindex
+
+import "strings"
+import "testing"
+
+func TestParseIisHtmlList(t *testing.T) {
+    got := ParseIisHtmlList(strings.NewReader(readTestdata(t, "iis.html")))
+    checkObjects(t, "iis.html", got, []FsObject{
+        {otype: FS_DIR, name: "sub", size: 4096, time: testTime("2024-01-02T11:00:00Z")},
+        {otype: FS_FILE, name: "a b.txt", size: 1234, time: testTime("2023-12-31T21:05:00Z")},
+        {otype: FS_FILE, name: "long.txt", size: 99, time: testTime("2024-01-01T10:00:00Z")},
+    })
+}
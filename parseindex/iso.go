@@ -0,0 +1,134 @@
+package parseindex
+
+// ISO 9660 images on HTTP mounts, members are streamed with range requests
+// and REST maps to an offset in the member extents
+
+import "errors"
+import "fmt"
+import "cfg"
+import "ftpIO"
+import "io"
+import "iso9660"
+import "net/http"
+
+func openIso(mount cfg.Mount, isoPath string) (*iso9660.Image, error) {
+    r, err := openArchive(mount, isoPath)
+    if err != nil {
+        return nil, err
+    }
+    img, err := iso9660.Open(r)
+    if err != nil {
+        fmt.Println("Cannot read ISO image:", err.Error())
+        return nil, &ReplyError{Code: 550, Text: "Not a valid ISO image."}
+    }
+    return img, nil
+}
+
+func isoLookup(img *iso9660.Image, innerPath string) (iso9660.Entry, error) {
+    entry, err := img.Lookup(innerPath)
+    if errors.Is(err, iso9660.ErrNotFound) {
+        return entry, errArchiveNotFound
+    }
+    return entry, err
+}
+
+func listIso(mount cfg.Mount, isoPath string, innerPath string) (FsObjectSlice, error) {
+    var objects FsObjectSlice
+    img, err := openIso(mount, isoPath)
+    if err != nil {
+        return objects, err
+    }
+    dir, err := isoLookup(img, innerPath)
+    if err != nil {
+        return objects, err
+    }
+    if dir.IsDir != true {
+        return objects, errArchiveNotFound
+    }
+    entries, err := img.ReadDir(dir)
+    if err != nil {
+        return objects, err
+    }
+    curObj := new(FsObject)
+    for _, entry := range entries {
+        curObj.name = entry.Name
+        curObj.time = entry.Time
+        curObj.size = entry.Size
+        if entry.IsDir {
+            curObj.otype = FS_DIR
+        } else {
+            curObj.otype = FS_FILE
+        }
+        objects = append(objects, *curObj)
+    }
+    return objects, nil
+}
+
+// Member data, one range request per extent
+type isoMemberReader struct {
+    host string
+    isoPath string
+    extents []iso9660.Extent
+    resp *http.Response
+}
+
+func (m *isoMemberReader) Read(p []byte) (int, error) {
+    for {
+        if m.resp != nil {
+            n, err := m.resp.Body.Read(p)
+            if err != io.EOF {
+                return n, err
+            }
+            ftpIO.CloseUrl(m.resp)
+            m.resp = nil
+            if n > 0 {
+                return n, nil
+            }
+        }
+        if len(m.extents) == 0 {
+            return 0, io.EOF
+        }
+        extent := m.extents[0]
+        m.extents = m.extents[1:]
+        if extent.Length == 0 {
+            continue
+        }
+        if err := ftpIO.OpenUrlRange(m.host, m.isoPath, extent.Offset, extent.Length, &m.resp); err != nil {
+            m.resp = nil
+            return 0, err
+        }
+    }
+}
+
+func (m *isoMemberReader) Close() (error) {
+    if m.resp != nil {
+        ftpIO.CloseUrl(m.resp)
+        m.resp = nil
+    }
+    return nil
+}
+
+func openIsoMember(mount cfg.Mount, isoPath string, innerPath string, offset int64) (io.ReadCloser, error) {
+    img, err := openIso(mount, isoPath)
+    if err != nil {
+        return nil, err
+    }
+    entry, err := isoLookup(img, innerPath)
+    if err != nil {
+        return nil, err
+    }
+    if entry.IsDir {
+        return nil, errArchiveNotFound
+    }
+    // Skip whole extents before offset, then start within the next one
+    m := &isoMemberReader{host: mount.Host, isoPath: isoPath}
+    for _, extent := range entry.Extents {
+        if offset >= extent.Length {
+            offset -= extent.Length
+            continue
+        }
+        m.extents = append(m.extents, iso9660.Extent{Offset: extent.Offset + offset, Length: extent.Length - offset})
+        offset = 0
+    }
+    return m, nil
+}
@@ -0,0 +1,76 @@
+package parseindex
+
+// lighttpd mod_dirlisting: a table with "n", "m", "s" and "t" cells for
+// name, modification time, size ("1.2K") and type
+
+import "golang.org/x/net/html"
+import "io"
+import "strings"
+import "time"
+
+func ParseLighttpdHtmlList(r io.Reader) ([]FsObject) {
+    z := html.NewTokenizer(r)
+
+    var objects []FsObject
+    var curObj *FsObject
+    var cell string             // Class of the current td
+    var text string
+
+    for {
+    tt := z.Next()
+
+    switch tt {
+    case html.ErrorToken:
+        // End of the document, we're done
+        return objects
+    case html.StartTagToken:
+        t := z.Token()
+        switch t.Data {
+        case "tr":
+            curObj = nil
+        case "td":
+            cell = getTokenAttr(&t, "class")
+            text = ""
+        case "a":
+            if cell != "n" {
+                break
+            }
+            if object, ok := hrefEntry(getTokenAttr(&t, "href")); ok {
+                curObj = &object
+            }
+        }
+    case html.EndTagToken:
+        t := z.Token()
+        switch t.Data {
+        case "td":
+            if curObj == nil {
+                break
+            }
+            text = strings.TrimSpace(text)
+            switch cell {
+            case "m":
+                if tim, err := time.Parse("2006-Jan-02 15:04:05", text); err == nil {
+                    curObj.time = tim
+                }
+            case "s":
+                if size, ok := parseHumanSize(text); ok && curObj.otype == FS_FILE {
+                    curObj.size = size
+                }
+            case "t":
+                if text == "Directory" {
+                    curObj.otype = FS_DIR
+                    curObj.size = 4096 /* XXX fake size */
+                }
+            }
+            cell = ""
+        case "tr":
+            if curObj != nil {
+                objects = append(objects, *curObj)
+            }
+            curObj = nil
+        }
+    case html.TextToken:
+        text += string(z.Text())
+    }
+    }
+}
@@ -0,0 +1,13 @@
+package parseindex
+
+import "strings"
+import "testing"
+
+func TestParseLighttpdHtmlList(t *testing.T) {
+    got := ParseLighttpdHtmlList(strings.NewReader(readTestdata(t, "lighttpd.html")))
+    checkObjects(t, "lighttpd.html", got, []FsObject{
+        {otype: FS_DIR, name: "sub", size: 4096, time: testTime("2024-01-02T11:00:00Z")},
+        {otype: FS_FILE, name: "a b.txt", size: 1228, time: testTime("2024-01-01T10:00:33Z")},
+        {otype: FS_FILE, name: "tiny", size: 102, time: testTime("2024-01-01T10:00:33Z")},
+    })
+}
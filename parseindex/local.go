@@ -0,0 +1,100 @@
+package parseindex
+
+import "errors"
+import "fmt"
+import "cfg"
+import "io"
+import "os"
+import "path"
+import "path/filepath"
+import "strings"
+
+// Backend for local directories, mounted read-only from "file:///some/dir"
+type localBackend struct{}
+
+// Resolve relPath below root, following symlinks.
+// Fails if the result escapes root.
+func resolveLocalPath(root string, relPath string) (string, bool) {
+    if root == "" {
+        fmt.Println("Local mount without root directory")
+        return "", false
+    }
+    realRoot, err := filepath.EvalSymlinks(root)
+    if err != nil {
+        fmt.Println("Cannot resolve local mount root:", err.Error())
+        return "", false
+    }
+    // Cleaning an absolute path drops any leading ".."
+    fullPath := filepath.Join(realRoot, filepath.FromSlash(path.Clean("/" + relPath)))
+    realPath, err := filepath.EvalSymlinks(fullPath)
+    if err != nil {
+        fmt.Println("Cannot resolve local path:", err.Error())
+        return "", false
+    }
+    if realPath != realRoot && !strings.HasPrefix(realPath, realRoot + string(filepath.Separator)) {
+        fmt.Printf("WARNING! Local path %s escapes mount root %s\n", realPath, realRoot)
+        return "", false
+    }
+    return realPath, true
+}
+
+func (localBackend) List(mount cfg.Mount, dirName string) (FsObjectSlice, error) {
+    var objects FsObjectSlice
+    relPath := mountRelPath(mount, dirName)
+    dirPath, ret := resolveLocalPath(mount.Path, relPath)
+    if ret != true {
+        return objects, errors.New("cannot resolve local path")
+    }
+    entries, err := os.ReadDir(dirPath)
+    if err != nil {
+        fmt.Println("Cannot read local directory:", err.Error())
+        return objects, err
+    }
+    curObj := new(FsObject)
+    for _, entry := range entries {
+        // Resolve each entry as well so that escaping symlinks are not listed
+        entryPath, ret := resolveLocalPath(mount.Path, path.Join(relPath, entry.Name()))
+        if ret != true {
+            continue
+        }
+        info, err := os.Stat(entryPath)
+        if err != nil {
+            continue
+        }
+        if info.IsDir() {
+            curObj.otype = FS_DIR
+        } else if info.Mode().IsRegular() {
+            curObj.otype = FS_FILE
+        } else {
+            continue
+        }
+        curObj.name = entry.Name()
+        curObj.time = info.ModTime()
+        curObj.size = info.Size()
+        objects = append(objects, *curObj)
+    }
+    return objects, nil
+}
+
+func (localBackend) Open(mount cfg.Mount, filePath string, offset int64) (io.ReadCloser, error) {
+    localPath, ret := resolveLocalPath(mount.Path, mountRelPath(mount, filePath))
+    if ret != true {
+        return nil, errors.New("cannot resolve local path")
+    }
+    file, err := os.Open(localPath)
+    if err != nil {
+        fmt.Println("Cannot open local file:", err.Error())
+        return nil, err
+    }
+    info, err := file.Stat()
+    if err != nil || !info.Mode().IsRegular() {
+        fmt.Printf("Not a regular file: %s\n", localPath)
+        file.Close()
+        return nil, errors.New("not a regular file")
+    }
+    if _, err = file.Seek(offset, io.SeekStart); err != nil {
+        file.Close()
+        return nil, err
+    }
+    return file, nil
+}
@@ -1,8 +1,11 @@
 package parseindex
 
+import "encoding/json"
+import "encoding/xml"
 import "fmt"
 import "golang.org/x/net/html"
 import "io"
+import "net/http"
 import "regexp"
 import "strconv"
 import "strings"
@@ -73,3 +76,66 @@ func ParseNginxHtmlList(r io.Reader) ([]FsObject) {
     }
     }
 }
+
+// Entry of nginx "autoindex_format json"
+type nginxJsonEntry struct {
+    Name string `json:"name"`
+    Type string `json:"type"`        // "directory", "file" or "other"
+    Mtime string `json:"mtime"`      // RFC 1123, GMT
+    Size *int64 `json:"size"`        // Only for files
+}
+
+// Parse the listing of nginx "autoindex_format json"
+func ParseNginxJsonList(r io.Reader) ([]FsObject) {
+    var entries []nginxJsonEntry
+    var objects []FsObject
+    if err := json.NewDecoder(r).Decode(&entries); err != nil {
+        fmt.Println("Invalid nginx json listing:", err.Error())
+        return objects
+    }
+    for _, entry := range entries {
+        tim, err := http.ParseTime(entry.Mtime)
+        if err != nil {
+            tim = time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
+        }
+        objects = append(objects, nginxObject(entry.Name, entry.Type, tim, entry.Size))
+    }
+    return objects
+}
+
+// Parse the listing of nginx "autoindex_format xml":
+// <list><directory mtime="...">name</directory><file mtime="..." size="n">name</file></list>
+func ParseNginxXmlList(r io.Reader) ([]FsObject) {
+    var list struct {
+        Entries []struct {
+            XMLName xml.Name
+            Mtime string `xml:"mtime,attr"`
+            Size *int64 `xml:"size,attr"`
+            Name string `xml:",chardata"`
+        } `xml:",any"`
+    }
+    var objects []FsObject
+    if err := xml.NewDecoder(r).Decode(&list); err != nil {
+        fmt.Println("Invalid nginx xml listing:", err.Error())
+        return objects
+    }
+    for _, entry := range list.Entries {
+        tim, err := time.Parse(time.RFC3339, entry.Mtime)
+        if err != nil {
+            tim = time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
+        }
+        objects = append(objects, nginxObject(entry.Name, entry.XMLName.Local, tim, entry.Size))
+    }
+    return objects
+}
+
+func nginxObject(name string, entryType string, tim time.Time, size *int64) (FsObject) {
+    object := FsObject{name: name, time: tim, size: -1, otype: FS_FILE}
+    if entryType == "directory" {
+        object.otype = FS_DIR
+        object.size = 4096 /* XXX fake size */
+    } else if size != nil {
+        object.size = *size
+    }
+    return object
+}
@@ -0,0 +1,31 @@
+package parseindex
+
+import "strings"
+import "testing"
+
+func TestParseNginxJsonList(t *testing.T) {
+    got := ParseNginxJsonList(strings.NewReader(readTestdata(t, "nginx.json")))
+    checkObjects(t, "nginx.json", got, []FsObject{
+        {otype: FS_FILE, name: "a very long file name that nginx html would truncate.tar.gz", size: 123456789, time: testTime("2024-01-01T10:00:33Z")},
+        {otype: FS_DIR, name: "sub", size: 4096, time: testTime("2024-01-02T11:00:00Z")},
+    })
+}
+
+func TestParseNginxXmlList(t *testing.T) {
+    got := ParseNginxXmlList(strings.NewReader(readTestdata(t, "nginx.xml")))
+    checkObjects(t, "nginx.xml", got, []FsObject{
+        {otype: FS_DIR, name: "sub", size: 4096, time: testTime("2024-01-02T11:00:00Z")},
+        {otype: FS_FILE, name: "x & y.txt", size: 42, time: testTime("2024-01-01T10:00:33Z")},
+    })
+}
+
+func TestParseNginxInvalid(t *testing.T) {
+    // An html page given to a forced json or xml parser lists nothing
+    page := readTestdata(t, "nginx.html")
+    if got := ParseNginxJsonList(strings.NewReader(page)); len(got) != 0 {
+        t.Errorf("json parser on html: %v", got)
+    }
+    if got := ParseNginxXmlList(strings.NewReader(page)); len(got) != 0 {
+        t.Errorf("xml parser on html: %v", got)
+    }
+}
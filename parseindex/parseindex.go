@@ -1,10 +1,15 @@
 package parseindex
 
+import "context"
+import "errors"
 import "fmt"
 import "golang.org/x/net/html"
 import "cfg"
+import "contentcache"
 import "ftpIO"
-import "net/http"
+import "io"
+import "net"
+import "os"
 import "path"
 import "sort"
 import "strings"
@@ -22,11 +27,129 @@ type FsObject struct {
     otype FsObjectType
     name string
     time time.Time
-    size int64
+    size int64      // -1 if unknown
+    etag string     // Only known to some backends
+    virtual bool    // Not an upstream file, e.g. an uncompressed name
+    link string     // Symlink target, the upstream redirects there
 }
 
 type FsObjectSlice []FsObject
 
+// A backend serves listings and file contents for one mount type
+type Backend interface {
+    // List the objects of dirName, a full path starting with the mount prefix
+    List(mount cfg.Mount, dirName string) (FsObjectSlice, error)
+    // Open filePath for reading from offset, the caller must Close() it
+    Open(mount cfg.Mount, filePath string, offset int64) (io.ReadCloser, error)
+}
+
+// Backends by mount scheme
+var backends = map[string]Backend{
+    "http": httpBackend{},
+    "file": localBackend{},
+    "ftp": ftpBackend{},
+    "s3": s3Backend{},
+    "s3+http": s3Backend{},
+    "dav": webdavBackend{},
+    "davs": webdavBackend{},
+    "git": gitBackend{},
+}
+
+// An error carrying the FTP reply the client should get
+type ReplyError struct {
+    Code int
+    Text string
+}
+
+func (e *ReplyError) Error() (string) {
+    return fmt.Sprintf("%d %s", e.Code, e.Text)
+}
+
+// Return the FTP reply for err: 550 for missing or forbidden files, 451
+// for upstream errors, timeouts and corrupted data, 421 for an unreachable
+// upstream and 426 for a broken or truncated transfer. Unknown errors get the given default reply.
+func ErrorReply(err error, code int, text string) (int, string) {
+    var replyErr *ReplyError
+    if errors.As(err, &replyErr) {
+        return replyErr.Code, replyErr.Text
+    }
+    var integrityErr *ftpIO.IntegrityError
+    if errors.As(err, &integrityErr) {
+        return 451, fmt.Sprintf("Transfer aborted, upstream data corrupted (%s).", integrityErr.Reason)
+    }
+    var transferErr *ftpIO.TransferError
+    if errors.As(err, &transferErr) {
+        return 426, fmt.Sprintf("Connection closed; transfer aborted (%s).", shortReason(err))
+    }
+    var urlErr *ftpIO.UrlError
+    if errors.As(err, &urlErr) && urlErr.StatusCode != 0 {
+        switch {
+        case urlErr.StatusCode == 404 || urlErr.StatusCode == 410:
+            return 550, "No such file or directory."
+        case urlErr.StatusCode == 401 || urlErr.StatusCode == 403:
+            return 550, "Permission denied."
+        case urlErr.StatusCode < 400:
+            return 550, fmt.Sprintf("Upstream redirect refused by policy (HTTP %d).", urlErr.StatusCode)
+        case urlErr.StatusCode < 500:
+            return 550, fmt.Sprintf("Upstream refused the request (HTTP %d).", urlErr.StatusCode)
+        }
+        return 451, fmt.Sprintf("Upstream error (HTTP %d).", urlErr.StatusCode)
+    }
+    var netErr net.Error
+    if errors.As(err, &netErr) && netErr.Timeout() || errors.Is(err, context.DeadlineExceeded) {
+        return 451, "Upstream timed out."
+    }
+    var opErr *net.OpError
+    var dnsErr *net.DNSError
+    if errors.As(err, &opErr) || errors.As(err, &dnsErr) {
+        return 421, fmt.Sprintf("Upstream unreachable (%s).", shortReason(err))
+    }
+    if errors.Is(err, os.ErrNotExist) {
+        return 550, "No such file or directory."
+    }
+    if errors.Is(err, os.ErrPermission) {
+        return 550, "Permission denied."
+    }
+    if err != nil {
+        return code, fmt.Sprintf("%s (%s).", strings.TrimSuffix(text, "."), shortReason(err))
+    }
+    return code, text
+}
+
+// Return the innermost message of err, e.g. "connection refused"
+func shortReason(err error) (string) {
+    for {
+        inner := errors.Unwrap(err)
+        if inner == nil {
+            break
+        }
+        err = inner
+    }
+    reason := err.Error()
+    if len(reason) > 80 {
+        reason = reason[:80]
+    }
+    return reason
+}
+
+func getBackend(mount cfg.Mount) (Backend, error) {
+    backend, exists := backends[mount.Scheme]
+    if exists != true {
+        fmt.Printf("WARNING! Unknown mount type: %s\n", mount.Scheme)
+        return nil, fmt.Errorf("unknown mount type: %s", mount.Scheme)
+    }
+    if mount.Scheme != "file" && mount.Scheme != "git" {
+        // Remote upstream
+        return failoverBackend{inner: backend}, nil
+    }
+    return backend, nil
+}
+
+// Return the path of fullPath relative to the mount prefix, without leading slash
+func mountRelPath(mount cfg.Mount, fullPath string) (string) {
+    return strings.Trim(strings.TrimPrefix(fullPath, mount.Prefix), "/")
+}
+
 func (f FsObjectSlice) Len() int {
     return len(f)
 }
@@ -49,12 +172,47 @@ func GenDirList(objects []FsObject) (string) {
         } else {
             printTime = object.time.Format(time.Stamp)
         }
+        if object.link != "" {
+            listing = fmt.Sprintf("%slrwxrwxrwx 1 ftp ftp %d %s %s -> %s\r\n", listing, len(object.link), printTime, object.name, object.link)
+            continue
+        }
         if object.otype == FS_DIR {
             lineHdr = "d"
         } else {
             lineHdr = "-"
         }
-        listing = fmt.Sprintf("%s%srwxr-xr-x 1 ftp ftp %d %s %s\r\n", listing, lineHdr, object.size, printTime, object.name)
+        size := object.size
+        if size < 0 {
+            size = 0
+        }
+        listing = fmt.Sprintf("%s%srwxr-xr-x 1 ftp ftp %d %s %s\r\n", listing, lineHdr, size, printTime, object.name)
+    }
+    return listing
+}
+
+// Return the MLSD/MLST facts of object followed by its name, e.g.
+// "type=file;size=42;modify=20240101120000; name"
+func mlsxEntry(object FsObject) (string) {
+    var facts string
+    switch {
+    case object.link != "" && strings.ContainsAny(object.link, ";\r\n") != true:
+        facts = "type=OS.unix=slink:" + object.link + ";"
+    case object.otype == FS_DIR:
+        facts = "type=dir;"
+    case object.size >= 0:
+        facts = fmt.Sprintf("type=file;size=%d;", object.size)
+    default:
+        facts = "type=file;"
+    }
+    facts += "modify=" + object.time.UTC().Format("20060102150405") + ";"
+    return facts + " " + object.name
+}
+
+// Same as GenDirList(), in the MLSD format
+func GenMlsdList(objects []FsObject) (string) {
+    var listing string
+    for _, object := range objects {
+        listing += mlsxEntry(object) + "\r\n"
     }
     return listing
 }
@@ -69,7 +227,21 @@ func getTokenAttr(tok *html.Token, attrName string) (string) {
 }
 
 func GetFSObjects(dirName string) (FsObjectSlice, bool) {
-    cfg.LoadConfig("ftproxy.conf")
+    objects, err := ListFSObjects(dirName)
+    if err != nil {
+        fmt.Println("GetFSObjects():", err.Error())
+        return objects, false
+    }
+    return objects, true
+}
+
+func ListFSObjects(dirName string) (FsObjectSlice, error) {
+    objects, _, err := listFSObjects(dirName)
+    return objects, err
+}
+
+// Same as ListFSObjects(), also tells if the listing is a stale cached one
+func listFSObjects(dirName string) (FsObjectSlice, bool, error) {
     dirName = path.Clean(dirName)
     var objects FsObjectSlice
 
@@ -88,31 +260,105 @@ func GetFSObjects(dirName string) (FsObjectSlice, bool) {
         // Always return root directory entries in the same order
         sort.Sort(objects)
     } else {
-        vhost := cfg.GetVhost(dirName)
-        var resp *http.Response
-        ret := ftpIO.OpenUrl(vhost, dirName, &resp)
-        if ret != true {
-            return objects, false
+        mount := cfg.GetMount(dirName)
+        objects, stale, err := cachedList(mount, dirName, func(validators *listingValidators) (FsObjectSlice, error) {
+            if archivePath, innerPath, ret := splitArchivePath(mount, dirName); ret == true {
+                return listArchive(mount, archivePath, innerPath)
+            }
+            backend, err := getBackend(mount)
+            if err != nil {
+                return nil, err
+            }
+            if lister, ok := backend.(conditionalLister); ok {
+                return lister.ListIf(mount, dirName, validators)
+            }
+            return backend.List(mount, dirName)
+        })
+        if err == nil && mount.Decompress {
+            objects = addDecompressedNames(dirName, objects)
         }
-        fmt.Printf("Server header: %s\n", resp.Header["Server"][0])
-        if strings.Contains(resp.Header["Server"][0], "nginx") {
-            objects = ParseNginxHtmlList(resp.Body)
-        } else {
-            objects = ParseApacheHtmlList(resp.Body)
+        return objects, stale, err
+    }
+    return objects, false, nil
+}
+
+// Open a file for RETR starting at offset, do not forget to Close() it
+func OpenFile(filePath string, offset int64) (io.ReadCloser, error) {
+    filePath = path.Clean(filePath)
+    mount := cfg.GetMount(filePath)
+    if archivePath, innerPath, ret := splitArchivePath(mount, filePath); ret == true && innerPath != "" {
+        return openArchiveMember(mount, archivePath, innerPath, offset)
+    }
+    if dirPath, suffix, ret := splitDirArchivePath(filePath); ret == true && mount.DirArchives && isDirArchive(filePath, dirPath) {
+        return openDirArchive(mount, dirPath, suffix, offset)
+    }
+    if mount.Decompress {
+        if compressedPath, ret := findCompressedFile(filePath); ret == true {
+            return openDecompressed(mount, filePath, compressedPath, offset)
         }
-        ftpIO.CloseUrl(resp)
     }
-    return objects, true
+    backend, err := getBackend(mount)
+    if err != nil {
+        return nil, err
+    }
+    if mount.ContentCache && contentcache.Enabled() {
+        return openCached(mount, backend, filePath, offset)
+    }
+    if mount.Scheme == "http" && mount.Segments > 1 {
+        return openSegmented(mount, backend, filePath, offset)
+    }
+    return backend.Open(mount, filePath, offset)
 }
 
 func DirList(path string) (string, bool) {
-    objects, ret := GetFSObjects(path)
-    if ret != true {
+    listing, _, err := ListDir(path)
+    if err != nil {
         return "", false
     }
-    return GenDirList(objects), true
+    return listing, true
+}
+
+// Return the listing of path, and a warning if it was served stale
+func ListDir(path string) (string, string, error) {
+    return listDir(path, GenDirList)
+}
+
+// Same as ListDir(), in the MLSD format
+func ListDirMlsd(path string) (string, string, error) {
+    return listDir(path, GenMlsdList)
+}
+
+func listDir(path string, genList func([]FsObject) (string)) (string, string, error) {
+    objects, stale, err := listFSObjects(path)
+    if err != nil {
+        return "", "", err
+    }
+    if stale == true {
+        return genList(objects), STALE_LISTING_WARNING, nil
+    }
+    return genList(objects), "", nil
 }
 
+// Return the MLST facts of filePath, followed by filePath
+func MlstEntry(filePath string) (string, error) {
+    if filePath == "/" {
+        return "type=dir; /", nil
+    }
+    dirName, name := path.Split(filePath)
+    objects, err := ListFSObjects(dirName)
+    if err != nil {
+        return "", err
+    }
+    for _, object := range objects {
+        if object.name == name {
+            object.name = filePath
+            return mlsxEntry(object), nil
+        }
+    }
+    return "", &ReplyError{Code: 550, Text: "No such file or directory."}
+}
+
+// Return size (-1 if unknown) and modification time of a file
 func FileStat(filePath string) (int64, string, bool) {
     dirName, fileName := path.Split(filePath)
     objects, ret := GetFSObjects(dirName)
@@ -120,7 +366,7 @@ func FileStat(filePath string) (int64, string, bool) {
         for _, object := range objects {
             if object.name == fileName && object.otype == FS_FILE {
                 fmt.Printf("Found file, size is: %d, time is: %s\n", object.size, object.time)
-                return object.size, object.time.Format("20060102030405"), true
+                return object.size, object.time.UTC().Format("20060102150405"), true
             }
         }
     }
@@ -136,6 +382,13 @@ func IsDir(dirPath string) (bool) {
         return true
     }
 
+    // Archives are directories too
+    mount := cfg.GetMount(dirPath)
+    if _, innerPath, ret := splitArchivePath(mount, dirPath); ret == true && innerPath == "" {
+        _, ret = GetFSObjects(dirPath)
+        return ret
+    }
+
     objects, ret := GetFSObjects(parentName)
     if ret == true {
         for _, object := range objects {
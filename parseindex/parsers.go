@@ -0,0 +1,193 @@
+package parseindex
+
+// Index page parsers of HTTP mounts. The format is detected from the start
+// of the page, then the Server header, or forced per mount with "parser".
+
+import "bufio"
+import "fmt"
+import "cfg"
+import "io"
+import "math"
+import "net/http"
+import "net/url"
+import "regexp"
+import "strconv"
+import "strings"
+import "time"
+
+// Bytes of the page given to the sniffers
+const SNIFF_SIZE = 4096
+
+// Size suffixes, in powers of 1024
+const SIZE_SUFFIXES = "KMGTPE"
+
+var humanSizeRe = regexp.MustCompile("^([0-9]+(?:\\.[0-9]+)?)([KMGTPEkmgtpe])?$")
+
+type indexParser struct {
+    name string
+    // Tells from its content type and start (head) if the page is in this format
+    sniff func(header http.Header, head string) (bool)
+    // Server header of the format, only checked if no sniffer matched
+    server string
+    parse func(r io.Reader) ([]FsObject)
+    // Instead of parse, for parsers resolving links against the page url
+    parseUrl func(r io.Reader, pageUrl *url.URL) ([]FsObject)
+    accept string       // Accept header requesting the format, if needed
+}
+
+// Sniffed in order, the last one is the fallback. Markup is more telling
+// than the Server header: a proxy or a custom index template may hide it.
+var indexParsers = []indexParser{
+    {name: "caddy-json", sniff: sniffCaddyJson, parse: ParseCaddyJsonList, accept: "application/json"},
+    {name: "nginx-json", sniff: sniffNginxJson, parse: ParseNginxJsonList},
+    {name: "nginx-xml", sniff: sniffNginxXml, parse: ParseNginxXmlList},
+    {name: "nginx", sniff: sniffNginx, server: "nginx", parse: ParseNginxHtmlList},
+    {name: "lighttpd", sniff: sniffLighttpd, server: "lighttpd", parse: ParseLighttpdHtmlList},
+    {name: "caddy", sniff: sniffCaddy, server: "Caddy", parse: ParseCaddyHtmlList},
+    {name: "iis", sniff: sniffIis, server: "Microsoft-IIS", parse: ParseIisHtmlList},
+    {name: "python", sniff: sniffPython, server: "SimpleHTTP", parse: ParsePythonHtmlList},
+    {name: "apache", sniff: sniffApache, server: "Apache", parse: ParseApacheHtmlList},
+    {name: "generic", parseUrl: ParseGenericHtmlList},
+}
+
+func sniffCaddyJson(header http.Header, head string) (bool) {
+    return strings.HasPrefix(strings.TrimSpace(head), "[") && strings.Contains(head, "\"is_dir\":")
+}
+
+func sniffNginxJson(header http.Header, head string) (bool) {
+    if strings.HasPrefix(header.Get("Content-Type"), "application/json") {
+        return true
+    }
+    return strings.HasPrefix(strings.TrimSpace(head), "[") && strings.Contains(head, "\"name\":")
+}
+
+func sniffNginxXml(header http.Header, head string) (bool) {
+    contentType := header.Get("Content-Type")
+    if strings.HasPrefix(contentType, "text/xml") || strings.HasPrefix(contentType, "application/xml") {
+        return true
+    }
+    return strings.HasPrefix(strings.TrimSpace(head), "<?xml") && strings.Contains(head, "<list>")
+}
+
+func sniffNginx(header http.Header, head string) (bool) {
+    return strings.Contains(head, "<h1>Index of ") && strings.Contains(head, "<pre><a href=\"../\">../</a>")
+}
+
+func sniffLighttpd(header http.Header, head string) (bool) {
+    return strings.Contains(head, "summary=\"Directory Listing\"")
+}
+
+func sniffCaddy(header http.Header, head string) (bool) {
+    return strings.Contains(head, "data-size=\"")
+}
+
+func sniffIis(header http.Header, head string) (bool) {
+    return strings.Contains(head, "[To Parent Directory]")
+}
+
+func sniffPython(header http.Header, head string) (bool) {
+    return strings.Contains(head, "<title>Directory listing for ")
+}
+
+func sniffApache(header http.Header, head string) (bool) {
+    return strings.Contains(head, "alt=\"[DIR]\"") || strings.Contains(head, "alt=\"[PARENTDIR]\"") ||
+        strings.Contains(head, "Parent Directory</a>") || strings.Contains(head, "<address>Apache") ||
+        strings.Contains(head, "href=\"?C=N;O=D\"")
+}
+
+// Return the parser of a page from its headers and start
+func sniffParser(header http.Header, head string) (*indexParser) {
+    for i := range indexParsers {
+        if indexParsers[i].sniff != nil && indexParsers[i].sniff(header, head) {
+            return &indexParsers[i]
+        }
+    }
+    server := header.Get("Server")
+    for i := range indexParsers {
+        if indexParsers[i].server != "" && strings.Contains(server, indexParsers[i].server) {
+            return &indexParsers[i]
+        }
+    }
+    return &indexParsers[len(indexParsers) - 1]
+}
+
+// Return the parser named name, nil if there is none
+func getParser(name string) (*indexParser) {
+    for i := range indexParsers {
+        if indexParsers[i].name == name {
+            return &indexParsers[i]
+        }
+    }
+    return nil
+}
+
+// Parse the index page of resp, with the parser of the mount or the one
+// matching the page
+func parseIndex(mount cfg.Mount, resp *http.Response) ([]FsObject) {
+    body := bufio.NewReaderSize(resp.Body, SNIFF_SIZE)
+    var parser *indexParser
+    if mount.Parser != "" {
+        parser = getParser(mount.Parser)
+        if parser == nil {
+            fmt.Printf("WARNING! Unknown parser: %s, detecting it\n", mount.Parser)
+        }
+    }
+    if parser != nil && parser.sniff != nil {
+        // e.g. html from nginx-json mounts without the indexQuery the upstream needs
+        head, _ := body.Peek(SNIFF_SIZE)
+        if parser.sniff(resp.Header, string(head)) != true {
+            fmt.Printf("WARNING! %s does not look like a %s index\n", resp.Request.URL, parser.name)
+        }
+    }
+    if parser == nil {
+        // A short page gives an EOF, sniff what there is
+        head, _ := body.Peek(SNIFF_SIZE)
+        parser = sniffParser(resp.Header, string(head))
+    }
+    fmt.Printf("Parsing %s as %s index\n", resp.Request.URL, parser.name)
+    if parser.parseUrl != nil {
+        return parser.parseUrl(body, resp.Request.URL)
+    }
+    return parser.parse(body)
+}
+
+// Return the object of a relative entry link, a directory if it ends with
+// a slash, false for sort, parent and other links
+func hrefEntry(href string) (FsObject, bool) {
+    u, err := url.Parse(href)
+    if err != nil || u.Scheme != "" || u.Host != "" || u.RawQuery != "" || u.Fragment != "" {
+        return FsObject{}, false
+    }
+    // Names with a colon are written "./name"
+    name := strings.TrimPrefix(u.Path, "./")
+    if strings.HasPrefix(name, "/") || strings.HasPrefix(name, "../") {
+        return FsObject{}, false
+    }
+    object := FsObject{otype: FS_FILE, size: -1, time: time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)}
+    if strings.HasSuffix(name, "/") {
+        object.otype = FS_DIR
+        object.size = 4096 /* XXX fake size */
+        name = strings.TrimSuffix(name, "/")
+    }
+    if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+        return FsObject{}, false
+    }
+    object.name = name
+    return object, true
+}
+
+// Parse a size like "17", "1.2K" or "2.5T", suffixes in powers of 1024
+func parseHumanSize(text string) (int64, bool) {
+    match := humanSizeRe.FindStringSubmatch(strings.TrimSpace(text))
+    if match == nil {
+        return 0, false
+    }
+    size, err := strconv.ParseFloat(match[1], 64)
+    if err != nil {
+        return 0, false
+    }
+    if match[2] != "" {
+        size *= math.Pow(1024, float64(strings.Index(SIZE_SUFFIXES, strings.ToUpper(match[2])) + 1))
+    }
+    return int64(size), true
+}
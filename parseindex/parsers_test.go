@@ -0,0 +1,86 @@
+package parseindex
+
+import "net/http"
+import "os"
+import "path/filepath"
+import "testing"
+import "time"
+
+func readTestdata(t *testing.T, name string) (string) {
+    data, err := os.ReadFile(filepath.Join("testdata", name))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return string(data)
+}
+
+func TestSniffParser(t *testing.T) {
+    tests := []struct {
+        file string
+        server string
+        want string
+    }{
+        {"nginx.html", "", "nginx"},
+        {"nginx.json", "", "nginx-json"},
+        {"nginx.xml", "", "nginx-xml"},
+        {"apache-table.html", "", "apache"},
+        {"apache-table-minimal.html", "", "apache"},
+        {"apache-pre.html", "", "apache"},
+        {"apache-pre-nosize.html", "", "apache"},
+        {"apache-ul.html", "", "apache"},
+        {"lighttpd.html", "", "lighttpd"},
+        {"caddy.html", "", "caddy"},
+        {"caddy.json", "", "caddy-json"},
+        {"iis.html", "", "iis"},
+        {"python.html", "", "python"},
+        {"generic.html", "", "generic"},
+        // The markup wins, e.g. an Apache behind an nginx proxy
+        {"apache-table.html", "nginx/1.24.0", "apache"},
+        {"python.html", "Apache/2.4.57", "python"},
+        {"generic.html", "lighttpd/1.4.73", "lighttpd"},
+        {"generic.html", "SimpleHTTP/0.6 Python/3.11.7", "python"},
+    }
+    for _, test := range tests {
+        header := http.Header{}
+        if test.server != "" {
+            header.Set("Server", test.server)
+        }
+        if got := sniffParser(header, readTestdata(t, test.file)).name; got != test.want {
+            t.Errorf("%s with Server %q: sniffed %s, want %s", test.file, test.server, got, test.want)
+        }
+    }
+}
+
+func TestSniffParserContentType(t *testing.T) {
+    header := http.Header{"Content-Type": {"application/json"}}
+    if got := sniffParser(header, "[]").name; got != "nginx-json" {
+        t.Errorf("empty json listing: sniffed %s, want nginx-json", got)
+    }
+    header = http.Header{"Content-Type": {"text/xml"}}
+    if got := sniffParser(header, "").name; got != "nginx-xml" {
+        t.Errorf("empty xml listing: sniffed %s, want nginx-xml", got)
+    }
+}
+
+func testTime(value string) (time.Time) {
+    tim, err := time.Parse(time.RFC3339Nano, value)
+    if err != nil {
+        panic(err)
+    }
+    return tim
+}
+
+// Compare the type, name, size, time and link target of listed objects
+func checkObjects(t *testing.T, label string, got []FsObject, want []FsObject) {
+    t.Helper()
+    if len(got) != len(want) {
+        t.Errorf("%s: got %d objects, want %d: %v", label, len(got), len(want), got)
+        return
+    }
+    for i := range want {
+        g, w := got[i], want[i]
+        if g.otype != w.otype || g.name != w.name || g.size != w.size || g.time.Equal(w.time) != true || g.link != w.link {
+            t.Errorf("%s: object %d is %+v, want %+v", label, i, g, w)
+        }
+    }
+}
@@ -0,0 +1,40 @@
+package parseindex
+
+// Python http.server: a <ul> of links, directories ending with a slash.
+// There are no sizes or times.
+
+import "golang.org/x/net/html"
+import "io"
+
+func ParsePythonHtmlList(r io.Reader) ([]FsObject) {
+    z := html.NewTokenizer(r)
+
+    var objects []FsObject
+    var inLi bool
+
+    for {
+    tt := z.Next()
+
+    switch tt {
+    case html.ErrorToken:
+        // End of the document, we're done
+        return objects
+    case html.StartTagToken:
+        t := z.Token()
+        if t.Data == "li" {
+            inLi = true
+        }
+        if inLi && t.Data == "a" {
+            // The text of symlinks is "name@", the link is the name
+            if object, ok := hrefEntry(getTokenAttr(&t, "href")); ok {
+                objects = append(objects, object)
+            }
+        }
+    case html.EndTagToken:
+        t := z.Token()
+        if t.Data == "li" {
+            inLi = false
+        }
+    }
+    }
+}
@@ -0,0 +1,16 @@
+package parseindex
+
+import "strings"
+import "testing"
+
+func TestParsePythonHtmlList(t *testing.T) {
+    // Python lists names only, symlinks ("lnk@") are plain entries
+    epoch := testTime("1970-01-01T00:00:00Z")
+    got := ParsePythonHtmlList(strings.NewReader(readTestdata(t, "python.html")))
+    checkObjects(t, "python.html", got, []FsObject{
+        {otype: FS_DIR, name: "h", size: 4096, time: epoch},
+        {otype: FS_FILE, name: "lnk", size: -1, time: epoch},
+        {otype: FS_DIR, name: "sub", size: 4096, time: epoch},
+        {otype: FS_FILE, name: "x.txt", size: -1, time: epoch},
+    })
+}
@@ -0,0 +1,110 @@
+package parseindex
+
+import "errors"
+import "fmt"
+import "cfg"
+import "ftpIO"
+import "io"
+import "s3"
+import "strings"
+import "time"
+
+// Backend for S3-compatible object storage, mounted from
+// "s3://host[:port]/bucket[/prefix]" (HTTPS) or "s3+http://..."
+type s3Backend struct{}
+
+// Return the client for mount and the key prefix of the mount root
+func s3Client(mount cfg.Mount) (*s3.Client, string) {
+    endpoint := "https://" + mount.Host
+    if mount.Scheme == "s3+http" {
+        endpoint = "http://" + mount.Host
+    }
+    pieces := strings.SplitN(strings.Trim(mount.Path, "/"), "/", 2)
+    var keyPrefix string
+    if len(pieces) > 1 && pieces[1] != "" {
+        keyPrefix = pieces[1] + "/"
+    }
+    client := &s3.Client{
+        Endpoint: endpoint,
+        Bucket: pieces[0],
+        Region: mount.Region,
+        AccessKey: mount.AccessKey,
+        SecretKey: mount.SecretKey,
+        HttpClient: ftpIO.HostClient(mount.Host),
+    }
+    return client, keyPrefix
+}
+
+func s3ReplyError(err error) (error) {
+    var s3Err *s3.Error
+    if !errors.As(err, &s3Err) {
+        return err
+    }
+    switch s3Err.StatusCode {
+    case 404:
+        return &ReplyError{Code: 550, Text: "No such file or directory."}
+    case 403:
+        return &ReplyError{Code: 550, Text: "Permission denied."}
+    }
+    return &ReplyError{Code: 451, Text: fmt.Sprintf("Upstream error: %s", s3Err.Code)}
+}
+
+func (s3Backend) List(mount cfg.Mount, dirName string) (FsObjectSlice, error) {
+    var objects FsObjectSlice
+    client, keyPrefix := s3Client(mount)
+    relPath := mountRelPath(mount, dirName)
+    if relPath != "" {
+        keyPrefix += relPath + "/"
+    }
+    s3Objects, s3Prefixes, err := client.List(keyPrefix)
+    if err != nil {
+        fmt.Println("S3 listing failed:", err.Error())
+        return objects, s3ReplyError(err)
+    }
+    curObj := new(FsObject)
+    for _, s3Prefix := range s3Prefixes {
+        // Prefixes have no metadata, fake it
+        curObj.name = strings.Trim(strings.TrimPrefix(s3Prefix, keyPrefix), "/")
+        curObj.time = time.Now()
+        curObj.size = 4096 /* XXX fake size */
+        curObj.otype = FS_DIR
+        if curObj.name != "" {
+            objects = append(objects, *curObj)
+        }
+    }
+    for _, s3Object := range s3Objects {
+        curObj.name = strings.TrimPrefix(s3Object.Key, keyPrefix)
+        curObj.time = s3Object.LastModified
+        curObj.size = s3Object.Size
+        curObj.etag = s3Object.ETag
+        curObj.otype = FS_FILE
+        // Skip "directory marker" objects
+        if curObj.name != "" && !strings.HasSuffix(curObj.name, "/") {
+            objects = append(objects, *curObj)
+        }
+    }
+    return objects, nil
+}
+
+func (b s3Backend) Open(mount cfg.Mount, filePath string, offset int64) (io.ReadCloser, error) {
+    return b.OpenIf(mount, filePath, offset, &fileVersion{size: -1})
+}
+
+// The version is the ETag, the same on every replica of the object
+func (s3Backend) OpenIf(mount cfg.Mount, filePath string, offset int64, version *fileVersion) (io.ReadCloser, error) {
+    client, keyPrefix := s3Client(mount)
+    resp, err := client.GetIf(keyPrefix + mountRelPath(mount, filePath), offset, version.tag)
+    var s3Err *s3.Error
+    if errors.As(err, &s3Err) && s3Err.StatusCode == 412 {
+        return nil, errFileChanged
+    }
+    if err != nil {
+        fmt.Println("S3 GetObject failed:", err.Error())
+        return nil, s3ReplyError(err)
+    }
+    if err = version.match(resp.Header.Get("ETag"), ftpIO.FileSize(resp)); err != nil {
+        resp.Body.Close()
+        return nil, err
+    }
+    return ftpIO.VerifyBody(resp), nil
+}
@@ -0,0 +1,173 @@
+package parseindex
+
+// Segmented RETR from HTTP mounts: large files are fetched as concurrent
+// Range requests of segmentSize bytes. At most `segments` segments are
+// fetched or buffered ahead of the client, and they are sent in order.
+// Segments may come from any mirror, If-Range keeps them on the version
+// of the file the HEAD request saw. The first mirror answering a segment
+// with the whole file (no ranges, or another version) turns the rest of
+// the transfer into a single stream.
+
+import "context"
+import "errors"
+import "fmt"
+import "cfg"
+import "ftpIO"
+import "io"
+import "metrics"
+import "net/http"
+
+type segment struct {
+    offset int64
+    length int64
+    done chan struct{}
+    data []byte
+    err error
+}
+
+type segmentedReader struct {
+    mount cfg.Mount
+    set *endpointSet
+    opener conditionalOpener    // For the single stream
+    filePath string
+    version fileVersion         // From the HEAD request
+    segments []*segment
+    window int                  // Segments fetched ahead
+    current int                 // Segment being read
+    pos int64                   // In the current segment
+    stream io.ReadCloser        // Once segments are given up
+    ctx context.Context
+    cancel context.CancelFunc   // Aborts the segments being fetched
+}
+
+// Open filePath at offset as parallel ranges, or as a single stream if
+// the file is small or has no validator to keep the ranges consistent
+func openSegmented(mount cfg.Mount, backend Backend, filePath string, offset int64) (io.ReadCloser, error) {
+    opener, ok := backend.(conditionalOpener)
+    if ok != true {
+        return backend.Open(mount, filePath, offset)
+    }
+    host, err := pickHost(mount)
+    if err != nil {
+        return nil, err
+    }
+    var resp *http.Response
+    if err := ftpIO.HeadUrl(host, filePath, &resp); err != nil {
+        // Let the single stream report the error
+        return backend.Open(mount, filePath, offset)
+    }
+    version := fileVersion{tag: ftpIO.IfRangeValidator(resp.Header), size: resp.ContentLength}
+    if version.tag == "" || version.size < 0 || version.size - offset < mount.SegmentThreshold {
+        return backend.Open(mount, filePath, offset)
+    }
+    fmt.Printf("Fetching %s in %d byte segments, %d at a time\n", filePath, mount.SegmentSize, mount.Segments)
+    metrics.Inc("ftproxy_segmented_transfers_total")
+    ctx, cancel := context.WithCancel(context.Background())
+    r := &segmentedReader{
+        mount: mount,
+        set: getEndpointSet(mount),
+        opener: opener,
+        filePath: filePath,
+        version: version,
+        window: mount.Segments,
+        ctx: ctx,
+        cancel: cancel,
+    }
+    for start := offset; start < version.size; start += mount.SegmentSize {
+        length := mount.SegmentSize
+        if start + length > version.size {
+            length = version.size - start
+        }
+        r.segments = append(r.segments, &segment{offset: start, length: length, done: make(chan struct{})})
+    }
+    for i := 0; i < r.window && i < len(r.segments); i++ {
+        go r.fetch(r.segments[i])
+    }
+    return r, nil
+}
+
+// A segment answered by the whole file
+func wholeFileSent(err error) (bool) {
+    var urlErr *ftpIO.UrlError
+    return errors.As(err, &urlErr) && urlErr.StatusCode == http.StatusOK
+}
+
+func (r *segmentedReader) fetch(s *segment) {
+    defer close(s.done)
+    s.err = r.set.do(nil, func(e *endpoint) (error) {
+        var resp *http.Response
+        err := ftpIO.OpenUrlRangeContext(r.ctx, e.host, r.filePath, s.offset, s.length, r.version.tag, &resp)
+        if err == nil {
+            s.data = make([]byte, s.length)
+            _, err = io.ReadFull(resp.Body, s.data)
+            ftpIO.CloseUrl(resp)
+        }
+        if r.ctx.Err() != nil {
+            // Aborted by Close(), not the endpoint's fault
+            r.set.release(e)
+            return r.ctx.Err()
+        }
+        // A whole file is an answer, not retriable
+        r.set.done(e, err, false)
+        return err
+    })
+}
+
+// Give up segments, the rest of the file comes as one stream of the same version
+func (r *segmentedReader) openStream(offset int64) (error) {
+    r.cancel()
+    fmt.Printf("Upstream sent the whole of %s for a segment, continuing at %d as a single stream\n", r.filePath, offset)
+    version := r.version
+    if r.current == 0 {
+        // Nothing sent yet, any version will do
+        version = fileVersion{size: -1}
+    }
+    stream, err := r.opener.OpenIf(r.mount, r.filePath, offset, &version)
+    if err != nil {
+        r.stream = errReader{err}
+        return err
+    }
+    r.stream = stream
+    return nil
+}
+
+func (r *segmentedReader) Read(p []byte) (int, error) {
+    if r.stream != nil {
+        return r.stream.Read(p)
+    }
+    if r.current >= len(r.segments) {
+        return 0, io.EOF
+    }
+    s := r.segments[r.current]
+    <-s.done
+    if wholeFileSent(s.err) {
+        if err := r.openStream(s.offset); err != nil {
+            return 0, err
+        }
+        return r.stream.Read(p)
+    }
+    if s.err != nil {
+        return 0, fmt.Errorf("segment at %d: %w", s.offset, s.err)
+    }
+    n := copy(p, s.data[r.pos:])
+    r.pos += int64(n)
+    if r.pos == s.length {
+        // Free the buffer and start the next segment of the window
+        s.data = nil
+        r.current++
+        r.pos = 0
+        if next := r.current + r.window - 1; next < len(r.segments) {
+            go r.fetch(r.segments[next])
+        }
+    }
+    return n, nil
+}
+
+// Abort the segments being fetched
+func (r *segmentedReader) Close() (error) {
+    r.cancel()
+    if r.stream != nil {
+        return r.stream.Close()
+    }
+    return nil
+}
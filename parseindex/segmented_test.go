@@ -0,0 +1,173 @@
+package parseindex
+
+import "bytes"
+import "cfg"
+import "errors"
+import "io"
+import "net/http"
+import "net/http/httptest"
+import "strconv"
+import "strings"
+import "sync"
+import "testing"
+import "time"
+
+// An upstream mirror serving one version of a file at any path
+type testMirror struct {
+    sync.Mutex
+    host string
+    data []byte
+    modified time.Time
+    etag string                 // Mirrors have their own
+    noRanges bool
+    dropAfter int               // GETs break after this many bytes, 0 never
+    gets int
+    lastRange string
+    lastIfRange string
+    onRequest func(m *testMirror, method string)   // Called locked, after the version is chosen
+}
+
+func newTestMirror(t *testing.T, data []byte, etag string) (*testMirror) {
+    m := &testMirror{data: data, modified: time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC), etag: etag}
+    server := httptest.NewServer(m)
+    t.Cleanup(server.Close)
+    m.host = strings.TrimPrefix(server.URL, "http://")
+    return m
+}
+
+func (m *testMirror) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    m.Lock()
+    data, modified := m.data, m.modified
+    w.Header().Set("ETag", m.etag)
+    if m.noRanges {
+        r.Header.Del("Range")
+    }
+    dropAfter := m.dropAfter
+    if r.Method == "GET" {
+        m.gets++
+        m.lastRange, m.lastIfRange = r.Header.Get("Range"), r.Header.Get("If-Range")
+    }
+    if m.onRequest != nil {
+        m.onRequest(m, r.Method)
+    }
+    m.Unlock()
+    if dropAfter > 0 && r.Method == "GET" {
+        w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+        w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+        w.Write(data[:dropAfter])
+        w.(http.Flusher).Flush()
+        panic(http.ErrAbortHandler)
+    }
+    http.ServeContent(w, r, "file", modified, bytes.NewReader(data))
+}
+
+// Endpoint sets are kept by prefix, each test needs its own and
+// forgets the one of an earlier run (go test -count)
+func testMirrorMount(prefix string, mirrors ...*testMirror) (cfg.Mount) {
+    endpoints.Lock()
+    delete(endpoints.sets, prefix)
+    endpoints.Unlock()
+    mount := cfg.Mount{Prefix: prefix, Scheme: "http", Balance: "round-robin", MaxFails: 3, FailTimeout: 30,
+        Segments: 3, SegmentSize: 100}
+    for _, m := range mirrors {
+        mount.Hosts = append(mount.Hosts, m.host)
+    }
+    mount.Host = mount.Hosts[0]
+    return mount
+}
+
+func testData(size int) ([]byte) {
+    data := make([]byte, size)
+    for i := range data {
+        data[i] = byte(i * 7 + i / 256)
+    }
+    return data
+}
+
+func readSegmented(t *testing.T, mount cfg.Mount, offset int64) ([]byte, error) {
+    t.Helper()
+    r, err := openSegmented(mount, failoverBackend{inner: httpBackend{}}, mount.Prefix + "/file", offset)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := r.(*segmentedReader); ok != true {
+        t.Fatalf("not segmented: %T", r)
+    }
+    defer r.Close()
+    return io.ReadAll(r)
+}
+
+func TestSegmentedMirrorEtags(t *testing.T) {
+    // Same file, ETags with different inodes: Last-Modified keeps them together
+    data := testData(1000)
+    a := newTestMirror(t, data, "\"inode-1\"")
+    b := newTestMirror(t, data, "\"inode-2\"")
+    mount := testMirrorMount("/seg-etags", a, b)
+    got, err := readSegmented(t, mount, 150)
+    if err != nil || bytes.Equal(got, data[150:]) != true {
+        t.Fatalf("read %d bytes, error %v", len(got), err)
+    }
+    a.Lock()
+    b.Lock()
+    if a.gets == 0 || b.gets == 0 {
+        t.Errorf("segments not spread: %d and %d GETs", a.gets, b.gets)
+    }
+    b.Unlock()
+    a.Unlock()
+    for _, e := range getEndpointSet(mount).endpoints {
+        if e.fails != 0 || e.breaker != BREAKER_CLOSED {
+            t.Errorf("endpoint %s has %d failures, breaker %d", e.host, e.fails, e.breaker)
+        }
+    }
+}
+
+func TestSegmentedNoRanges(t *testing.T) {
+    data := testData(1000)
+    a := newTestMirror(t, data, "\"a\"")
+    b := newTestMirror(t, data, "\"b\"")
+    b.noRanges = true
+    got, err := readSegmented(t, testMirrorMount("/seg-noranges", a, b), 0)
+    if err != nil || bytes.Equal(got, data) != true {
+        t.Fatalf("read %d bytes, error %v", len(got), err)
+    }
+}
+
+func TestSegmentedFileChanged(t *testing.T) {
+    data := testData(1000)
+    newData := bytes.Repeat([]byte("x"), 900)
+    m := newTestMirror(t, data, "\"v1\"")
+    newVersion := func(m *testMirror) {
+        m.data, m.etag = newData, "\"v2\""
+        m.modified = m.modified.Add(time.Hour)
+        m.onRequest = nil
+    }
+    mount := testMirrorMount("/seg-changed", m)
+    mount.Segments = 1
+    m.onRequest = func(m *testMirror, method string) {
+        if method == "GET" {
+            // Once the first segment is sent
+            newVersion(m)
+        }
+    }
+    got, err := readSegmented(t, mount, 0)
+    if errors.Is(err, errFileChanged) != true {
+        t.Errorf("changed file gives %v", err)
+    }
+    if bytes.Equal(got, data[:100]) != true {
+        t.Errorf("read %d bytes before the change", len(got))
+    }
+
+    // Changed before anything was sent: the new version is sent whole
+    m.Lock()
+    m.data, m.etag = data, "\"v1\""
+    m.onRequest = func(m *testMirror, method string) {
+        if method == "HEAD" {
+            newVersion(m)
+        }
+    }
+    m.Unlock()
+    got, err = readSegmented(t, mount, 0)
+    if err != nil || bytes.Equal(got, newData) != true {
+        t.Errorf("read %d bytes of the new version, error %v", len(got), err)
+    }
+}
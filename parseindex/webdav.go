@@ -0,0 +1,182 @@
+package parseindex
+
+import "encoding/xml"
+import "fmt"
+import "cfg"
+import "ftpIO"
+import "io"
+import "net/http"
+import "net/url"
+import "path"
+import "strconv"
+import "strings"
+import "time"
+
+// Backend for WebDAV servers, mounted from "dav://host[:port]/dir" or "davs://..." (HTTPS)
+type webdavBackend struct{}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop>
+<D:getcontentlength/><D:getlastmodified/><D:resourcetype/><D:getetag/>
+</D:prop></D:propfind>`
+
+type davMultistatus struct {
+    Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+    Href string `xml:"DAV: href"`
+    Propstats []struct {
+        Status string `xml:"DAV: status"`
+        Prop struct {
+            ContentLength string `xml:"DAV: getcontentlength"`
+            LastModified string `xml:"DAV: getlastmodified"`
+            ETag string `xml:"DAV: getetag"`
+            ResourceType struct {
+                Collection *struct{} `xml:"DAV: collection"`
+            } `xml:"DAV: resourcetype"`
+        } `xml:"DAV: prop"`
+    } `xml:"DAV: propstat"`
+}
+
+// Return the upstream URL of fullPath, credentials are not part of it
+func webdavUrl(mount cfg.Mount, fullPath string, isDir bool) (string) {
+    scheme := "http"
+    if mount.Scheme == "davs" {
+        scheme = "https"
+    }
+    upstreamPath := path.Join("/", mount.Path, mountRelPath(mount, fullPath))
+    if isDir && upstreamPath != "/" {
+        upstreamPath += "/"
+    }
+    u := url.URL{Scheme: scheme, Host: mount.Host, Path: upstreamPath}
+    return u.String()
+}
+
+// Build a request on fullPath, with the mount credentials as basic auth
+func webdavRequest(mount cfg.Mount, method string, fullPath string, isDir bool, body io.Reader) (*http.Request, error) {
+    req, err := http.NewRequest(method, webdavUrl(mount, fullPath, isDir), body)
+    if err != nil {
+        return nil, err
+    }
+    if mount.User != "" {
+        req.SetBasicAuth(mount.User, mount.Password)
+    }
+    return req, nil
+}
+
+func webdavReplyError(statusCode int) (error) {
+    switch statusCode {
+    case 404:
+        return &ReplyError{Code: 550, Text: "No such file or directory."}
+    case 401, 403:
+        return &ReplyError{Code: 550, Text: "Permission denied."}
+    }
+    return &ReplyError{Code: 451, Text: fmt.Sprintf("Upstream error: HTTP %d", statusCode)}
+}
+
+func (webdavBackend) List(mount cfg.Mount, dirName string) (FsObjectSlice, error) {
+    var objects FsObjectSlice
+    req, err := webdavRequest(mount, "PROPFIND", dirName, true, strings.NewReader(propfindBody))
+    if err != nil {
+        return objects, err
+    }
+    req.Header.Set("Depth", "1")
+    req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+    resp, err := ftpIO.HostClient(mount.Host).Do(req)
+    if err != nil {
+        fmt.Println("PROPFIND failed:", err.Error())
+        return objects, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != 207 {
+        fmt.Printf("PROPFIND %s returned %d\n", req.URL.Redacted(), resp.StatusCode)
+        return objects, webdavReplyError(resp.StatusCode)
+    }
+    var multistatus davMultistatus
+    if err = xml.NewDecoder(resp.Body).Decode(&multistatus); err != nil {
+        fmt.Println("Cannot parse PROPFIND response:", err.Error())
+        return objects, err
+    }
+
+    dirPath := path.Clean(req.URL.Path)
+    curObj := new(FsObject)
+    for _, response := range multistatus.Responses {
+        // href is either an absolute URL or an absolute path
+        hrefUrl, err := url.Parse(response.Href)
+        if err != nil {
+            continue
+        }
+        hrefPath := path.Clean(hrefUrl.Path)
+        if hrefPath == dirPath || path.Dir(hrefPath) != dirPath {
+            // The directory itself, or something unexpected
+            continue
+        }
+        curObj.name = path.Base(hrefPath)
+        curObj.otype = FS_NONE
+        curObj.size = 0
+        curObj.time = time.Time{}
+        curObj.etag = ""
+        for _, propstat := range response.Propstats {
+            if !strings.Contains(propstat.Status, " 200 ") {
+                continue
+            }
+            prop := propstat.Prop
+            if prop.ResourceType.Collection != nil {
+                curObj.otype = FS_DIR
+                curObj.size = 4096 /* XXX fake size */
+            } else {
+                curObj.otype = FS_FILE
+                curObj.size, _ = strconv.ParseInt(strings.TrimSpace(prop.ContentLength), 10, 64)
+            }
+            if tim, err := http.ParseTime(prop.LastModified); err == nil {
+                curObj.time = tim
+            }
+            curObj.etag = prop.ETag
+        }
+        if curObj.otype != FS_NONE {
+            objects = append(objects, *curObj)
+        }
+    }
+    return objects, nil
+}
+
+func (b webdavBackend) Open(mount cfg.Mount, filePath string, offset int64) (io.ReadCloser, error) {
+    return b.OpenIf(mount, filePath, offset, &fileVersion{size: -1})
+}
+
+// The version is the If-Range validator, a changed file is sent whole
+func (webdavBackend) OpenIf(mount cfg.Mount, filePath string, offset int64, version *fileVersion) (io.ReadCloser, error) {
+    req, err := webdavRequest(mount, "GET", filePath, false, nil)
+    if err != nil {
+        return nil, err
+    }
+    if offset > 0 {
+        req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+        if version.tag != "" {
+            req.Header.Set("If-Range", version.tag)
+        }
+    }
+    resp, err := ftpIO.HostClient(mount.Host).Do(req)
+    if err != nil {
+        fmt.Println("WebDAV GET failed:", err.Error())
+        return nil, err
+    }
+    if resp.StatusCode != 200 && resp.StatusCode != 206 {
+        resp.Body.Close()
+        return nil, webdavReplyError(resp.StatusCode)
+    }
+    if err = version.match(ftpIO.IfRangeValidator(resp.Header), ftpIO.FileSize(resp)); err != nil {
+        resp.Body.Close()
+        return nil, err
+    }
+    resp.Body = ftpIO.VerifyBody(resp)
+    if offset > 0 && resp.StatusCode == 200 {
+        // Range not supported by the server, skip to offset ourselves
+        if _, err = io.CopyN(io.Discard, resp.Body, offset); err != nil {
+            resp.Body.Close()
+            return nil, err
+        }
+    }
+    return resp.Body, nil
+}
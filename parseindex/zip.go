@@ -0,0 +1,141 @@
+package parseindex
+
+// ZIP archives on HTTP mounts, only the central directory and the requested
+// members are fetched
+
+import "archive/zip"
+import "compress/flate"
+import "errors"
+import "fmt"
+import "cfg"
+import "ftpIO"
+import "hash"
+import "hash/crc32"
+import "io"
+import "net/http"
+import "strings"
+
+func openZip(mount cfg.Mount, zipPath string) (*zip.Reader, *httpRangeReader, error) {
+    r, err := openArchive(mount, zipPath)
+    if err != nil {
+        return nil, nil, err
+    }
+    archive, err := zip.NewReader(r, r.size)
+    if err != nil {
+        fmt.Println("Cannot read zip archive:", err.Error())
+        return nil, nil, &ReplyError{Code: 550, Text: "Not a valid zip archive."}
+    }
+    return archive, r, nil
+}
+
+func listZip(mount cfg.Mount, zipPath string, innerPath string) (FsObjectSlice, error) {
+    var objects FsObjectSlice
+    archive, _, err := openZip(mount, zipPath)
+    if err != nil {
+        return objects, err
+    }
+    var dirPrefix string
+    if innerPath != "" {
+        dirPrefix = innerPath + "/"
+    }
+    found := innerPath == ""
+    seen := make(map[string]bool)
+    curObj := new(FsObject)
+    for _, file := range archive.File {
+        if !strings.HasPrefix(file.Name, dirPrefix) {
+            continue
+        }
+        found = true
+        relName := strings.TrimPrefix(file.Name, dirPrefix)
+        pieces := strings.SplitN(relName, "/", 2)
+        if pieces[0] == "" || seen[pieces[0]] {
+            continue
+        }
+        curObj.name = pieces[0]
+        curObj.time = file.Modified
+        if len(pieces) > 1 {
+            // Directories may only exist implicitly, through their members
+            curObj.otype = FS_DIR
+            curObj.size = 4096 /* XXX fake size */
+        } else {
+            curObj.otype = FS_FILE
+            curObj.size = int64(file.UncompressedSize64)
+        }
+        seen[pieces[0]] = true
+        objects = append(objects, *curObj)
+    }
+    if found != true {
+        return objects, errArchiveNotFound
+    }
+    return objects, nil
+}
+
+// Decompressed member data, the CRC is checked at the end
+type zipMemberReader struct {
+    resp *http.Response
+    r io.Reader
+    crc hash.Hash32
+    expectedCrc uint32
+}
+
+func (z *zipMemberReader) Read(p []byte) (int, error) {
+    n, err := z.r.Read(p)
+    z.crc.Write(p[:n])
+    if err == io.EOF && z.crc.Sum32() != z.expectedCrc {
+        return n, errors.New("zip member checksum mismatch")
+    }
+    return n, err
+}
+
+func (z *zipMemberReader) Close() (error) {
+    ftpIO.CloseUrl(z.resp)
+    return nil
+}
+
+// Stream one member of an archive, fetching only its compressed data
+func openZipMember(mount cfg.Mount, zipPath string, innerPath string, offset int64) (io.ReadCloser, error) {
+    archive, _, err := openZip(mount, zipPath)
+    if err != nil {
+        return nil, err
+    }
+    var member *zip.File
+    for _, file := range archive.File {
+        if file.Name == innerPath {
+            member = file
+            break
+        }
+    }
+    if member == nil || strings.HasSuffix(member.Name, "/") {
+        return nil, errArchiveNotFound
+    }
+    if member.Method != zip.Store && member.Method != zip.Deflate {
+        return nil, &ReplyError{Code: 550, Text: "Unsupported compression method in archive."}
+    }
+    if member.Flags & 0x1 != 0 {
+        return nil, &ReplyError{Code: 550, Text: "Encrypted archive member."}
+    }
+    dataOffset, err := member.DataOffset()
+    if err != nil {
+        return nil, err
+    }
+    z := &zipMemberReader{crc: crc32.NewIEEE(), expectedCrc: member.CRC32}
+    if member.CompressedSize64 > 0 {
+        if err := ftpIO.OpenUrlRange(mount.Host, zipPath, dataOffset, int64(member.CompressedSize64), &z.resp); err != nil {
+            return nil, err
+        }
+        z.r = io.LimitReader(z.resp.Body, int64(member.CompressedSize64))
+    } else {
+        z.r = strings.NewReader("")
+    }
+    if member.Method == zip.Deflate {
+        z.r = flate.NewReader(z.r)
+    }
+    if offset > 0 {
+        // No random access in compressed data
+        if _, err = io.CopyN(io.Discard, z, offset); err != nil {
+            z.Close()
+            return nil, err
+        }
+    }
+    return z, nil
+}
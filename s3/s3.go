@@ -0,0 +1,234 @@
+package s3
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/xml"
+    "fmt"
+    "net/http"
+    "net/url"
+    "sort"
+    "strings"
+    "time"
+)
+
+// SHA256 of an empty payload, all our requests are bodyless
+const EMPTY_SHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// A bucket on an S3-compatible server, accessed path-style
+type Client struct {
+    Endpoint string   // "https://host[:port]"
+    Bucket string
+    Region string
+    AccessKey string
+    SecretKey string
+    HttpClient *http.Client     // http.DefaultClient if nil
+}
+
+func (c *Client) httpClient() (*http.Client) {
+    if c.HttpClient == nil {
+        return http.DefaultClient
+    }
+    return c.HttpClient
+}
+
+type Object struct {
+    Key string
+    LastModified time.Time
+    Size int64
+    ETag string
+}
+
+type listResult struct {
+    Contents []Object
+    CommonPrefixes []struct {
+        Prefix string
+    }
+    IsTruncated bool
+    NextContinuationToken string
+}
+
+// An error response from the server
+type Error struct {
+    StatusCode int
+    Code string
+    Message string
+}
+
+func (e *Error) Error() (string) {
+    return fmt.Sprintf("S3 error %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// URI-encode as required by SigV4, keeping '/' if encodeSlash is false
+func uriEncode(s string, encodeSlash bool) (string) {
+    var encoded strings.Builder
+    for _, b := range []byte(s) {
+        if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') ||
+            b == '-' || b == '_' || b == '.' || b == '~' || (b == '/' && encodeSlash != true) {
+            encoded.WriteByte(b)
+        } else {
+            fmt.Fprintf(&encoded, "%%%02X", b)
+        }
+    }
+    return encoded.String()
+}
+
+func hmacSha256(key []byte, data string) ([]byte) {
+    h := hmac.New(sha256.New, key)
+    h.Write([]byte(data))
+    return h.Sum(nil)
+}
+
+// Build a request for key (may be empty) signed with SigV4,
+// amzHeaders are extra x-amz-* headers, signed too
+func (c *Client) newRequest(method string, key string, query url.Values, amzHeaders map[string]string) (*http.Request, error) {
+    canonicalUri := "/" + uriEncode(c.Bucket, true)
+    if key != "" {
+        canonicalUri += "/" + uriEncode(key, false)
+    }
+
+    var queryParts []string
+    for name, values := range query {
+        for _, value := range values {
+            queryParts = append(queryParts, uriEncode(name, true) + "=" + uriEncode(value, true))
+        }
+    }
+    sort.Strings(queryParts)
+    canonicalQuery := strings.Join(queryParts, "&")
+
+    rawUrl := strings.TrimRight(c.Endpoint, "/") + canonicalUri
+    if canonicalQuery != "" {
+        rawUrl += "?" + canonicalQuery
+    }
+    req, err := http.NewRequest(method, rawUrl, nil)
+    if err != nil {
+        return nil, err
+    }
+
+    now := time.Now().UTC()
+    amzDate := now.Format("20060102T150405Z")
+    req.Header.Set("x-amz-date", amzDate)
+    req.Header.Set("x-amz-content-sha256", EMPTY_SHA256)
+    for name, value := range amzHeaders {
+        req.Header.Set(name, value)
+    }
+    if c.AccessKey == "" {
+        // Anonymous access to a public bucket
+        return req, nil
+    }
+
+    // Host and all x-amz-* headers are signed, sorted by name
+    headerNames := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+    for name, _ := range amzHeaders {
+        headerNames = append(headerNames, strings.ToLower(name))
+    }
+    sort.Strings(headerNames)
+    canonicalHeaders := ""
+    for _, name := range headerNames {
+        value := req.Header.Get(name)
+        if name == "host" {
+            value = req.URL.Host
+        }
+        canonicalHeaders += name + ":" + strings.TrimSpace(value) + "\n"
+    }
+    signedHeaders := strings.Join(headerNames, ";")
+    canonicalRequest := strings.Join([]string{method, canonicalUri, canonicalQuery, canonicalHeaders, signedHeaders, EMPTY_SHA256}, "\n")
+    requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+    scope := fmt.Sprintf("%s/%s/s3/aws4_request", now.Format("20060102"), c.Region)
+    stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+    signingKey := hmacSha256([]byte("AWS4" + c.SecretKey), now.Format("20060102"))
+    signingKey = hmacSha256(signingKey, c.Region)
+    signingKey = hmacSha256(signingKey, "s3")
+    signingKey = hmacSha256(signingKey, "aws4_request")
+    signature := hex.EncodeToString(hmacSha256(signingKey, stringToSign))
+
+    req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", c.AccessKey, scope, signedHeaders, signature))
+    return req, nil
+}
+
+// Read an error response body into an *Error
+func readError(resp *http.Response) (error) {
+    s3Err := &Error{StatusCode: resp.StatusCode}
+    xml.NewDecoder(resp.Body).Decode(s3Err)
+    return s3Err
+}
+
+// List objects and common prefixes directly below prefix, following continuation tokens
+func (c *Client) List(prefix string) ([]Object, []string, error) {
+    var objects []Object
+    var prefixes []string
+    var token string
+    for {
+        query := url.Values{}
+        query.Set("list-type", "2")
+        query.Set("delimiter", "/")
+        query.Set("prefix", prefix)
+        if token != "" {
+            query.Set("continuation-token", token)
+        }
+        req, err := c.newRequest("GET", "", query, nil)
+        if err != nil {
+            return nil, nil, err
+        }
+        resp, err := c.httpClient().Do(req)
+        if err != nil {
+            return nil, nil, err
+        }
+        if resp.StatusCode != 200 {
+            err = readError(resp)
+            resp.Body.Close()
+            return nil, nil, err
+        }
+        var result listResult
+        err = xml.NewDecoder(resp.Body).Decode(&result)
+        resp.Body.Close()
+        if err != nil {
+            return nil, nil, err
+        }
+        objects = append(objects, result.Contents...)
+        for _, commonPrefix := range result.CommonPrefixes {
+            prefixes = append(prefixes, commonPrefix.Prefix)
+        }
+        if result.IsTruncated != true || result.NextContinuationToken == "" {
+            return objects, prefixes, nil
+        }
+        token = result.NextContinuationToken
+    }
+}
+
+// GetObject from offset, do not forget to close the response body
+func (c *Client) Get(key string, offset int64) (*http.Response, error) {
+    return c.GetIf(key, offset, "")
+}
+
+// Same as Get(), only if the object still has etag (412 PreconditionFailed if not).
+// Replicas of an object have the same ETag, unlike their LastModified.
+func (c *Client) GetIf(key string, offset int64, etag string) (*http.Response, error) {
+    // Ask for the x-amz-checksum-* headers of the object
+    req, err := c.newRequest("GET", key, nil, map[string]string{"x-amz-checksum-mode": "ENABLED"})
+    if err != nil {
+        return nil, err
+    }
+    if offset > 0 {
+        req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+    }
+    if etag != "" {
+        req.Header.Set("If-Match", etag)
+    }
+    resp, err := c.httpClient().Do(req)
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode != 200 && resp.StatusCode != 206 {
+        err = readError(resp)
+        resp.Body.Close()
+        return nil, err
+    }
+    if offset > 0 && resp.StatusCode == 200 {
+        resp.Body.Close()
+        return nil, &Error{StatusCode: resp.StatusCode, Code: "RangeIgnored", Message: "Server does not support ranges"}
+    }
+    return resp, nil
+}
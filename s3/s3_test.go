@@ -0,0 +1,291 @@
+package s3
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "sort"
+    "strings"
+    "testing"
+    "time"
+)
+
+const (
+    testAccessKey = "AKIDEXAMPLE"
+    testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+    testRegion = "eu-west-3"
+    testBucket = "bucket"
+)
+
+// RFC 3986 encoding, written out again to check uriEncode
+func testEncode(s string, path bool) (string) {
+    var encoded strings.Builder
+    for _, b := range []byte(s) {
+        if strings.IndexByte("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_.~", b) >= 0 || (path && b == '/') {
+            encoded.WriteByte(b)
+        } else {
+            fmt.Fprintf(&encoded, "%%%02X", b)
+        }
+    }
+    return encoded.String()
+}
+
+func testHmac(key []byte, data string) ([]byte) {
+    h := hmac.New(sha256.New, key)
+    io.WriteString(h, data)
+    return h.Sum(nil)
+}
+
+// Check the SigV4 Authorization header of r, return why it is wrong
+func checkSignature(r *http.Request) (string) {
+    auth := r.Header.Get("Authorization")
+    if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") {
+        return "no SigV4 authorization: " + auth
+    }
+    fields := make(map[string]string)
+    for _, field := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ", ") {
+        pieces := strings.SplitN(field, "=", 2)
+        if len(pieces) == 2 {
+            fields[pieces[0]] = pieces[1]
+        }
+    }
+    amzDate := r.Header.Get("x-amz-date")
+    if len(amzDate) != 16 {
+        return "bad x-amz-date: " + amzDate
+    }
+    day := amzDate[:8]
+    scope := day + "/" + testRegion + "/s3/aws4_request"
+    if fields["Credential"] != testAccessKey + "/" + scope {
+        return "bad credential: " + fields["Credential"]
+    }
+    if r.Header.Get("x-amz-content-sha256") != EMPTY_SHA256 {
+        return "bad payload hash"
+    }
+
+    signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+    if sort.StringsAreSorted(signedHeaders) != true {
+        return "signed headers not sorted"
+    }
+    var canonicalHeaders string
+    for _, name := range signedHeaders {
+        value := r.Header.Get(name)
+        if name == "host" {
+            value = r.Host
+        }
+        canonicalHeaders += name + ":" + strings.TrimSpace(value) + "\n"
+    }
+    for _, required := range []string{"host", "x-amz-content-sha256", "x-amz-date"} {
+        if strings.Contains(canonicalHeaders, required + ":") != true {
+            return "unsigned header " + required
+        }
+    }
+    var queryParts []string
+    for name, values := range r.URL.Query() {
+        for _, value := range values {
+            queryParts = append(queryParts, testEncode(name, false) + "=" + testEncode(value, false))
+        }
+    }
+    sort.Strings(queryParts)
+    canonicalRequest := strings.Join([]string{r.Method, testEncode(r.URL.Path, true), strings.Join(queryParts, "&"),
+        canonicalHeaders, fields["SignedHeaders"], EMPTY_SHA256}, "\n")
+    requestHash := sha256.Sum256([]byte(canonicalRequest))
+    stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+    key := testHmac([]byte("AWS4" + testSecretKey), day)
+    key = testHmac(key, testRegion)
+    key = testHmac(key, "s3")
+    key = testHmac(key, "aws4_request")
+    if signature := hex.EncodeToString(testHmac(key, stringToSign)); signature != fields["Signature"] {
+        return "signature mismatch for canonical request:\n" + canonicalRequest
+    }
+    return ""
+}
+
+// Fake server: listings come in pages of one object and one prefix
+type fakeS3 struct {
+    t *testing.T
+    objects map[string]string
+    listRequests int
+    lastRange string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if reason := checkSignature(r); reason != "" {
+        f.t.Errorf("%s %s: %s", r.Method, r.URL, reason)
+        w.WriteHeader(403)
+        fmt.Fprint(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>bad signature</Message></Error>")
+        return
+    }
+    if r.URL.Path == "/" + testBucket {
+        f.list(w, r)
+        return
+    }
+    key := strings.TrimPrefix(r.URL.Path, "/" + testBucket + "/")
+    data, exists := f.objects[key]
+    if exists != true {
+        w.WriteHeader(404)
+        fmt.Fprint(w, "<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>")
+        return
+    }
+    if r.Header.Get("x-amz-checksum-mode") != "ENABLED" {
+        f.t.Errorf("GET %s without checksum mode", key)
+    }
+    f.lastRange = r.Header.Get("Range")
+    if key == "norange" {
+        r.Header.Del("Range")
+    }
+    http.ServeContent(w, r, key, time.Time{}, strings.NewReader(data))
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+    f.listRequests++
+    query := r.URL.Query()
+    if query.Get("list-type") != "2" || query.Get("delimiter") != "/" {
+        f.t.Errorf("bad list query: %s", r.URL.RawQuery)
+    }
+    prefix := query.Get("prefix")
+    var keys []string
+    prefixes := make(map[string]bool)
+    for key := range f.objects {
+        if !strings.HasPrefix(key, prefix) {
+            continue
+        }
+        rest := strings.TrimPrefix(key, prefix)
+        if i := strings.Index(rest, "/"); i >= 0 {
+            prefixes[prefix + rest[:i + 1]] = true
+        } else {
+            keys = append(keys, key)
+        }
+    }
+    sort.Strings(keys)
+    var sortedPrefixes []string
+    for p := range prefixes {
+        sortedPrefixes = append(sortedPrefixes, p)
+    }
+    sort.Strings(sortedPrefixes)
+
+    // Page n has the n-th key and prefix, the token is the page number
+    page := 0
+    if token := query.Get("continuation-token"); token != "" {
+        fmt.Sscanf(token, "page %d", &page)
+    }
+    var body bytes.Buffer
+    body.WriteString("<ListBucketResult>")
+    if page < len(keys) {
+        fmt.Fprintf(&body, "<Contents><Key>%s</Key><LastModified>2024-01-02T03:04:05.000Z</LastModified><ETag>\"etag-%d\"</ETag><Size>%d</Size></Contents>",
+            keys[page], page, len(f.objects[keys[page]]))
+    }
+    if page < len(sortedPrefixes) {
+        fmt.Fprintf(&body, "<CommonPrefixes><Prefix>%s</Prefix></CommonPrefixes>", sortedPrefixes[page])
+    }
+    if page + 1 < len(keys) || page + 1 < len(sortedPrefixes) {
+        fmt.Fprintf(&body, "<IsTruncated>true</IsTruncated><NextContinuationToken>page %d</NextContinuationToken>", page + 1)
+    } else {
+        body.WriteString("<IsTruncated>false</IsTruncated>")
+    }
+    body.WriteString("</ListBucketResult>")
+    w.Write(body.Bytes())
+}
+
+func newTestClient(t *testing.T) (*Client, *fakeS3) {
+    fake := &fakeS3{t: t, objects: map[string]string{
+        "top.txt": "top",
+        "dir 1/a b.txt": "0123456789",
+        "dir 1/c+d.txt": "c",
+        "dir 1/e~f.txt": "e",
+        "dir 1/sub/x": "x",
+        "dir2/y": "y",
+        "norange": "0123456789",
+    }}
+    server := httptest.NewServer(fake)
+    t.Cleanup(server.Close)
+    client := &Client{Endpoint: server.URL, Bucket: testBucket, Region: testRegion, AccessKey: testAccessKey, SecretKey: testSecretKey}
+    return client, fake
+}
+
+func TestList(t *testing.T) {
+    client, fake := newTestClient(t)
+    objects, prefixes, err := client.List("dir 1/")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var keys []string
+    for _, object := range objects {
+        keys = append(keys, object.Key)
+    }
+    if strings.Join(keys, "|") != "dir 1/a b.txt|dir 1/c+d.txt|dir 1/e~f.txt" {
+        t.Errorf("keys are %q", keys)
+    }
+    if strings.Join(prefixes, "|") != "dir 1/sub/" {
+        t.Errorf("prefixes are %q", prefixes)
+    }
+    if fake.listRequests != 3 {
+        t.Errorf("%d list requests, want 3 pages", fake.listRequests)
+    }
+    if objects[0].Size != 10 || objects[0].ETag != "\"etag-0\"" || objects[0].LastModified.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)) != true {
+        t.Errorf("first object is %+v", objects[0])
+    }
+
+    objects, prefixes, err = client.List("")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(objects) != 2 || strings.Join(prefixes, "|") != "dir 1/|dir2/" {
+        t.Errorf("root has %d objects and prefixes %q", len(objects), prefixes)
+    }
+}
+
+func TestGet(t *testing.T) {
+    client, fake := newTestClient(t)
+    resp, err := client.Get("dir 1/a b.txt", 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    data, _ := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if string(data) != "0123456789" || fake.lastRange != "" {
+        t.Errorf("full get is %q, range %q", data, fake.lastRange)
+    }
+
+    resp, err = client.Get("dir 1/a b.txt", 4)
+    if err != nil {
+        t.Fatal(err)
+    }
+    data, _ = io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if resp.StatusCode != 206 || string(data) != "456789" || fake.lastRange != "bytes=4-" {
+        t.Errorf("ranged get is %d %q, range %q", resp.StatusCode, data, fake.lastRange)
+    }
+
+    // A full body at an offset would be served from the wrong position
+    _, err = client.Get("norange", 4)
+    var s3Err *Error
+    if errors.As(err, &s3Err) != true || s3Err.Code != "RangeIgnored" {
+        t.Errorf("ignored range gives %v", err)
+    }
+
+    _, err = client.Get("missing", 0)
+    if errors.As(err, &s3Err) != true || s3Err.StatusCode != 404 || s3Err.Code != "NoSuchKey" {
+        t.Errorf("missing key gives %v", err)
+    }
+}
+
+func TestAnonymous(t *testing.T) {
+    client := &Client{Endpoint: "http://s3.test", Bucket: testBucket, Region: testRegion}
+    req, err := client.newRequest("GET", "a b", url.Values{"prefix": {"x y"}}, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if req.Header.Get("Authorization") != "" {
+        t.Errorf("anonymous request signed")
+    }
+    if req.URL.String() != "http://s3.test/bucket/a%20b?prefix=x%20y" {
+        t.Errorf("url is %s", req.URL)
+    }
+}